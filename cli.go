@@ -0,0 +1,123 @@
+package webapp
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kaphos/webapp/pkg/db/migrate"
+	"github.com/kaphos/webapp/pkg/utils"
+	"io"
+	"os"
+	"time"
+)
+
+// ErrUnknownCommand is returned by Run if the command is not recognised.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// Run dispatches on command-line arguments (typically os.Args[1:]), allowing the same
+// binary to be used for maintenance tasks. With no arguments (or "serve"), the server is
+// started (see Start). Other commands:
+//
+//	migrate up [-target N] [-dry-run]   applies pending migrations (up to version N)
+//	migrate down [-steps N] [-dry-run]  rolls back the N (default 1) latest migrations
+//	migrate status                      lists migrations, and whether they are applied
+//	seed [-truncate]                    inserts the fixtures (truncating their tables first,
+//	                                    which is refused if ENV is "prod")
+func (s *Server) Run(args []string) error {
+	if len(args) == 0 || args[0] == "serve" {
+		return s.Start()
+	}
+
+	switch args[0] {
+	case "migrate":
+		return s.runMigrate(args[1:], os.Stdout)
+	case "seed":
+		return s.runSeed(args[1:], os.Stdout)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, args[0])
+	}
+}
+
+func (s *Server) runMigrate(args []string, out io.Writer) error {
+	if s.migrator == nil {
+		return errors.New("no migrations registered; use WithMigrations")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("%w: migrate requires one of up, down or status", ErrUnknownCommand)
+	}
+
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	flags.SetOutput(out)
+	target := flags.Int64("target", migrate.Latest, "version to migrate up to (default: latest)")
+	steps := flags.Int("steps", 1, "number of migrations to roll back")
+	dryRun := flags.Bool("dry-run", false, "print the migrations to run, without running them")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var applied []migrate.Step
+	var err error
+
+	switch args[0] {
+	case "up":
+		applied, err = s.migrator.Migrate(ctx, s.DB, *target, *dryRun)
+	case "down":
+		applied, err = s.migrator.Rollback(ctx, s.DB, *steps, *dryRun)
+	case "status":
+		statuses, err := s.migrator.Status(ctx, s.DB)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			_, _ = fmt.Fprintf(out, "%d\t%s\t%s\n", status.Version, appliedAt, status.Description)
+		}
+		return s.migrator.Verify(ctx, s.DB)
+	default:
+		return fmt.Errorf("%w: migrate %s", ErrUnknownCommand, args[0])
+	}
+
+	for _, step := range applied {
+		_, _ = fmt.Fprintln(out, step.String())
+	}
+	if len(applied) == 0 && err == nil {
+		_, _ = fmt.Fprintln(out, "Nothing to migrate.")
+	}
+
+	return err
+}
+
+func (s *Server) runSeed(args []string, out io.Writer) error {
+	if s.fixtures == nil {
+		return errors.New("no fixtures registered; use WithFixtures")
+	}
+
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	flags.SetOutput(out)
+	truncate := flags.Bool("truncate", false, "truncate the fixtures' tables (and any referencing them) first")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	load := s.fixtures.Insert
+	if *truncate {
+		if utils.GetEnv("ENV", "") == "prod" {
+			return errors.New("refusing to truncate tables when ENV is \"prod\"")
+		}
+		load = s.fixtures.Reload
+	}
+	inserted, err := load(context.Background(), s.DB)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range s.fixtures.Tables() {
+		_, _ = fmt.Fprintf(out, "%s\t%d rows\n", table, len(inserted[table]))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/pkg/handler"
+	"github.com/kaphos/webapp/pkg/middleware"
+	"github.com/kaphos/webapp/pkg/repo"
+	"net/http"
+)
+
+type Comment struct {
+	Text string `json:"text" binding:"required"`
+}
+
+type ItemComments struct {
+	Item     Item      `json:"item"`
+	Comments []Comment `json:"comments"`
+}
+
+// CommentRepo is mounted under ItemRepo, at "/items/:itemId/comments".
+type CommentRepo struct{ repo.Repo[Comment] }
+
+func (r *CommentRepo) getComments(c *gin.Context) bool {
+	item, _ := repo.Parent[Item](c, "itemId")
+	c.JSON(http.StatusOK, ItemComments{Item: item, Comments: make([]Comment, 0)})
+	return true
+}
+
+// addComment runs within a transaction (see handler.WithTx), so the item is only marked
+// as edited if the comment is added successfully.
+func (r *CommentRepo) addComment(c *gin.Context, comment Comment) bool {
+	item, _ := repo.Parent[Item](c, "itemId")
+	err := r.DB.Exec("touchItem", c.Request.Context(), `UPDATE items SET edited = NOW() WHERE id = $1`, item.ID)
+	if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return false
+	}
+
+	c.JSON(http.StatusCreated, comment)
+	return true
+}
+
+func buildCommentRepo(authMiddleware middleware.Middleware) *CommentRepo {
+	r := CommentRepo{}
+	r.SetRelativePath("comments")
+
+	h := handler.NewU("GET", "/", r.getComments, 200, ItemComments{})
+	h.SetSummary("Retrieves the comments on an item.")
+	r.AddHandler(&h)
+
+	a := handler.NewP("POST", "/", r.addComment, 201, Comment{}, authMiddleware, handler.WithTx())
+	a.SetSummary("Adds a comment to an item.")
+	r.AddHandler(&a)
+
+	return &r
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/kaphos/webapp/pkg/webapptest"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// TestContract checks every documented operation against its documentation. Operations that
+// need the database are only checked if one is configured.
+func TestContract(t *testing.T) {
+	opts := []webapptest.ContractOption{
+		webapptest.WithAuthProvider(func(req *http.Request, groups []string) {
+			req.Header.Set("auth", "true")
+		}),
+		webapptest.WithParam("id", "3fa85f64-5717-4562-b3fc-2c963f66afa6"),
+	}
+
+	s, _ := setup()
+	if os.Getenv(webapptest.EnvURL) != "" {
+		webapptest.Isolate(t, s)
+	} else {
+		opts = append(opts,
+			webapptest.SkipOperation("GET", "/api/items/"),
+			webapptest.SkipOperation("POST", "/api/items/"),
+			webapptest.SkipOperation("POST", "/api/items/{itemId}/comments/"),
+			webapptest.SkipOperation("GET", "/api/tags/"),
+			webapptest.SkipOperation("POST", "/api/tags/"),
+			webapptest.SkipOperation("GET", "/api/users/"),
+		)
+	}
+
+	webapptest.Contract(t, s, opts...)
+}
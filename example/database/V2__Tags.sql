@@ -0,0 +1,10 @@
+CREATE TABLE tags (
+    id      SERIAL PRIMARY KEY,
+    created TIMESTAMP NOT NULL DEFAULT NOW(),
+    name    TEXT      NOT NULL,
+    colour  TEXT
+);
+
+INSERT INTO tags (name, colour)
+    VALUES ('Urgent', 'red'),
+           ('Later', NULL);
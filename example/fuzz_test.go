@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/kaphos/webapp/pkg/webapptest"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// FuzzPayloads fuzzes the request bodies of every handler taking a payload. Handlers that
+// need the database are only fuzzed if one is configured.
+func FuzzPayloads(f *testing.F) {
+	opts := []webapptest.ContractOption{
+		webapptest.WithAuthProvider(func(req *http.Request, groups []string) {
+			req.Header.Set("auth", "true")
+		}),
+		webapptest.WithParam("itemId", "3fa85f64-5717-4562-b3fc-2c963f66afa6"),
+	}
+	if os.Getenv(webapptest.EnvURL) == "" {
+		opts = append(opts,
+			webapptest.SkipOperation("POST", "/api/items/"),
+			webapptest.SkipOperation("POST", "/api/items/{itemId}/comments/"),
+			webapptest.SkipOperation("POST", "/api/tags/"),
+			webapptest.SkipOperation("PUT", "/api/tags/{id}/"),
+		)
+	}
+
+	s, _ := setup()
+	webapptest.Fuzz(f, s, opts...)
+}
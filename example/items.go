@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gofrs/uuid/v5"
+	"github.com/kaphos/webapp/pkg/db"
+	"github.com/kaphos/webapp/pkg/errchk"
 	"github.com/kaphos/webapp/pkg/handler"
 	"github.com/kaphos/webapp/pkg/middleware"
 	"github.com/kaphos/webapp/pkg/repo"
@@ -56,6 +58,25 @@ func (r *ItemRepo) createItem(c *gin.Context, item Item) bool {
 	return true
 }
 
+// resolveItem looks up the item that a comment belongs to.
+func (r *ItemRepo) resolveItem(c *gin.Context, id string) (Item, bool) {
+	itemID, err := uuid.FromString(id)
+	if err != nil {
+		return Item{}, false
+	}
+
+	item, err := db.QueryOne[Item](r.DB, "getItem", c.Request.Context(),
+		`SELECT id, created, edited, name, owner, found, count, price FROM items WHERE id = $1`, itemID)
+	if err == errchk.ErrNoRows {
+		return Item{}, false
+	} else if err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return Item{}, false
+	}
+
+	return item, true
+}
+
 func buildItemRepo(authMiddleware middleware.Middleware, userRepo *UserRepo) *ItemRepo {
 	r := ItemRepo{userRepo: userRepo}
 	r.SetRelativePath("items")
@@ -70,5 +91,8 @@ func buildItemRepo(authMiddleware middleware.Middleware, userRepo *UserRepo) *It
 	c.SetDescription("Only allowed by authenticated users.")
 	r.AddHandler(&c)
 
+	r.SetResolver(r.resolveItem)
+	r.AddSubRepo("itemId", buildCommentRepo(authMiddleware))
+
 	return &r
 }
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"github.com/gofrs/uuid/v5"
+	"github.com/kaphos/webapp/pkg/db"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"testing"
 )
 
 func TestGetItems(t *testing.T) {
-	s, w := setup()
+	s, w := setupDB(t)
 	req, _ := http.NewRequest("GET", "/api/items/", nil)
 	s.Router.ServeHTTP(w, req)
 	assert.Equal(t, 200, w.Code)
@@ -39,3 +43,32 @@ func TestAddItem(t *testing.T) {
 		})
 	}
 }
+
+func TestGetItemCommentsNotFound(t *testing.T) {
+	s, w := setup()
+	req, _ := http.NewRequest("GET", "/api/items/not-a-uuid/comments/", nil)
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAddItemCommentUnauthorised(t *testing.T) {
+	s, w := setup()
+	req, _ := http.NewRequest("POST", "/api/items/not-a-uuid/comments/", nil)
+	req.Header.Add("auth", "false")
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAddItemComment(t *testing.T) {
+	s, w := setupDB(t)
+	itemID, err := db.QueryScalar[uuid.UUID](s.DB, "getItemID", context.Background(), `SELECT id FROM items LIMIT 1`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	req, _ := http.NewRequest("POST", "/api/items/"+itemID.String()+"/comments/", bytes.NewBufferString(`{"text":"Hello"}`))
+	req.Header.Add("auth", "true")
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"text":"Hello"}`, w.Body.String())
+}
@@ -1,21 +1,36 @@
 package main
 
 import (
+	"embed"
 	"github.com/gin-gonic/gin"
 	"github.com/kaphos/webapp"
+	"github.com/kaphos/webapp/pkg/db"
 	"github.com/kaphos/webapp/pkg/middleware"
+	"log"
+	"os"
 )
 
+//go:embed database/*.sql
+var migrations embed.FS
+
+//go:embed fixtures/*.yml
+var fixtureFiles embed.FS
+
+//go:embed queries/*.sql
+var queryFiles embed.FS
+
+var namedQueries = db.MustLoadQueries(queryFiles)
+
 func main() {
 	s := setupServer()
 	_ = s.GenDocs([]webapp.APIServer{{URL: "http://localhost:5000", Description: "Dev server"}}, "swagger.yml")
-	if err := s.Start(); err != nil {
-		return
+	if err := s.Run(os.Args[1:]); err != nil {
+		log.Fatal(err)
 	}
 }
 
 func setupServer() *webapp.Server {
-	s, err := webapp.NewServer("Test App", "v1", "testuser", "testpass", 1)
+	s, err := webapp.NewServer("Test App", "v1", "testuser", "testpass", 1, webapp.WithMigrations(migrations), webapp.WithQueries(namedQueries), webapp.WithFixtures(fixtureFiles))
 	if err != nil {
 		return nil
 	}
@@ -24,6 +39,7 @@ func setupServer() *webapp.Server {
 	s.Attach(buildPingRepo())
 	userRepo := buildUserRepo()
 	s.Attach(buildItemRepo(authMiddleware, userRepo))
+	s.Attach(buildTagRepo())
 	s.Attach(userRepo) // can be placed after it is used in other repos, as long as the repo is ultimately attached
 	return &s
 }
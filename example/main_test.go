@@ -1,20 +1,23 @@
 package main
 
 import (
+	"context"
 	"github.com/kaphos/webapp"
+	"github.com/kaphos/webapp/pkg/webapptest"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 )
 
 func TestMain(m *testing.M) {
-	m.Run()
+	os.Exit(webapptest.Main(m, migrations))
 }
 
 func TestHealthcheck(t *testing.T) {
-	s, w := setup()
+	s, w := setupDB(t)
 	req, _ := http.NewRequest("GET", "/healthcheck", nil)
 	s.Router.ServeHTTP(w, req)
 	assert.Equal(t, 200, w.Code)
@@ -48,3 +51,39 @@ func setup() (*webapp.Server, *httptest.ResponseRecorder) {
 	w := httptest.NewRecorder()
 	return s, w
 }
+
+// client returns a client for making requests to s, authenticating requests using the "auth"
+// header checked by the example's auth middleware.
+func client(t *testing.T, s *webapp.Server) *webapptest.Client {
+	return webapptest.Do(t, s).UseAuth(func(req *http.Request, token string) {
+		req.Header.Set("auth", token)
+	})
+}
+
+// setupDB sets up a server for a test that needs the database, with its changes rolled back
+// once the test ends. Skips the test if no database is configured.
+func setupDB(t *testing.T) (*webapp.Server, *httptest.ResponseRecorder) {
+	s, w := setup()
+	webapptest.Isolate(t, s)
+	return s, w
+}
+
+func TestStartShutdown(t *testing.T) {
+	t.Setenv("PORT", "0")
+	s, err := webapp.NewServer("Test App", "v1", "testuser", "testpass", 1) // no migrations or queries to check against the database
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Start() }()
+
+	assert.Nil(t, s.Shutdown(context.Background()))
+	assert.Nil(t, <-done)
+}
+
+func TestSeedTruncateInProd(t *testing.T) {
+	t.Setenv("ENV", "prod")
+	s, _ := setup()
+	assert.ErrorContains(t, s.Run([]string{"seed", "-truncate"}), "refusing to truncate")
+}
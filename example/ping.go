@@ -1,11 +1,13 @@
 package main
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/kaphos/webapp/pkg/handler"
 	"github.com/kaphos/webapp/pkg/repo"
 	"go/types"
 	"net/http"
+	"strconv"
 )
 
 type PingRepo struct{ repo.Repo[types.Nil] }
@@ -15,10 +17,56 @@ func (r *PingRepo) ping(c *gin.Context) bool {
 	return true
 }
 
+type Pong struct {
+	Count int `json:"count"`
+}
+
+// pingStream sends a few pongs, resuming the count from Last-Event-ID if the client reconnects.
+func (r *PingRepo) pingStream(c *gin.Context, stream *handler.Emitter[Pong]) bool {
+	start, _ := strconv.Atoi(stream.LastEventID())
+
+	for i := start + 1; i <= start+3; i++ {
+		if err := stream.SendEvent(handler.Event[Pong]{ID: strconv.Itoa(i), Name: "pong", Data: Pong{i}}); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pingSocket replies to every "ping" message with a "pong" message, with the count incremented.
+func (r *PingRepo) pingSocket(c *gin.Context, conn *handler.Conn[Pong, Pong]) bool {
+	for {
+		msg, err := conn.Receive()
+		if errors.Is(err, handler.ErrInvalidMessage) {
+			continue
+		} else if err != nil {
+			return true // connection closed
+		}
+
+		if msg.Type != "ping" {
+			continue
+		}
+
+		if err := conn.SendEnvelope(handler.Envelope[Pong]{Type: "pong", ID: msg.ID, Data: Pong{msg.Data.Count + 1}}); err != nil {
+			return true
+		}
+	}
+}
+
 func buildPingRepo() repo.RepoI {
 	r := PingRepo{}
 	r.SetRelativePath("ping")
 	h := handler.NewU("GET", "/", r.ping, 200, "pong")
 	r.AddHandler(&h)
+
+	s := handler.NewSSE("/stream", r.pingStream)
+	s.SetSummary("Streams a few pongs.")
+	r.AddHandler(&s)
+
+	ws := handler.NewWS("/socket", r.pingSocket)
+	ws.SetSummary("Replies to pings over a WebSocket connection.")
+	ws.SetMaxConnections(10)
+	r.AddHandler(&ws)
 	return &r
 }
@@ -2,8 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/kaphos/webapp/pkg/handler"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -18,3 +22,44 @@ func TestPing(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, resp, "pong")
 }
+
+func TestPingStream(t *testing.T) {
+	s, w := setup()
+	req, _ := http.NewRequest("GET", "/api/ping/stream", nil)
+	req.Header.Set("Last-Event-ID", "4")
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "id: 5\nevent: pong\ndata: {\"count\":5}\n\n"+
+		"id: 6\nevent: pong\ndata: {\"count\":6}\n\n"+
+		"id: 7\nevent: pong\ndata: {\"count\":7}\n\n", w.Body.String())
+}
+
+func TestPingSocket(t *testing.T) {
+	s, _ := setup()
+	server := httptest.NewServer(s.Router)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/ping/socket"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Nil(t, conn.WriteMessage(websocket.TextMessage, []byte("not json")))
+	assert.Nil(t, conn.WriteJSON(handler.Envelope[Pong]{Type: "ping", ID: "a", Data: Pong{41}}))
+
+	var resp handler.Envelope[Pong]
+	assert.Nil(t, conn.ReadJSON(&resp))
+	assert.Equal(t, handler.Envelope[Pong]{Type: "pong", ID: "a", Data: Pong{42}}, resp)
+}
+
+func TestPingSocketOrigin(t *testing.T) {
+	s, _ := setup()
+	server := httptest.NewServer(s.Router)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/ping/socket"
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://evil.example.com"}})
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
@@ -0,0 +1,5 @@
+-- Queries used by UserRepo. Each is prepared on startup, so typos are caught before
+-- the endpoint is hit.
+
+-- name: getUsers
+SELECT id, name, email, admin, groups, age FROM users;
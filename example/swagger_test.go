@@ -69,8 +69,32 @@ func TestSwagger(t *testing.T) {
 	assert.Equal(t, pathItems.Post.Summary, "Creates a new item.")
 	assert.Equal(t, pathItems.Post.Description, "Only allowed by authenticated users.")
 
+	pathStream, found := api.Paths["/ping/stream/"]
+	assert.True(t, found)
+	streamSchema := pathStream.Get.Responses[200].Content["text/event-stream"].Schema
+	assert.Equal(t, "integer", streamSchema.Properties["count"].Type)
+
+	pathComments, found := api.Paths["/items/{itemId}/comments/"]
+	assert.True(t, found)
+	assert.Equal(t, []string{"items/comments"}, pathComments.Get.Tags)
+	assert.Equal(t, "itemId", pathComments.Parameters[0].Name)
+	assert.Equal(t, "path", pathComments.Parameters[0].In)
+	assert.Equal(t, "Identifies the parent items entry", pathComments.Parameters[0].Description)
+	_, found = pathComments.Get.Responses[404]
+	assert.True(t, found)
+
 	pathUsers, found := api.Paths["/users/"]
 	assert.True(t, found)
+	getUsersSchema := pathUsers.Get.Responses[200].Content["application/json"].Schema
+	assert.Equal(t, "array", getUsersSchema.Properties["data"].Type)
+	assert.Equal(t, "string", getUsersSchema.Properties["next"].Type)
+	paramNames := make([]string, 0)
+	for _, param := range pathUsers.Parameters {
+		paramNames = append(paramNames, param.Name)
+	}
+	assert.ElementsMatch(t, []string{"limit", "cursor", "filter[name][eq]", "filter[name][like]", "filter[admin][eq]",
+		"filter[groups][eq]", "filter[groups][in]", "filter[age][lt]", "filter[age][gte]"}, paramNames)
+
 	createUserSchema := pathUsers.Post.RequestBody.Content["application/json"].Schema
 	assert.Equal(t, "integer", createUserSchema.Properties["id"].Type)
 	assert.Equal(t, "int", createUserSchema.Properties["id"].Format)
@@ -87,4 +111,23 @@ func TestSwagger(t *testing.T) {
 	assert.Equal(t, true, createUserSchema.Example["admin"])
 	assert.Equal(t, 31, createUserSchema.Example["groups"])
 	assert.Equal(t, 12.3, createUserSchema.Example["age"])
+
+	pathTags, found := api.Paths["/tags/"]
+	assert.True(t, found)
+	assert.Equal(t, "Lists tags.", pathTags.Get.Summary)
+	_, found = pathTags.Post.Responses[201]
+	assert.True(t, found)
+	_, found = pathTags.Post.Responses[403]
+	assert.True(t, found)
+
+	pathTag, found := api.Paths["/tags/{id}/"]
+	assert.True(t, found)
+	assert.Equal(t, "id", pathTag.Parameters[0].Name)
+	assert.Equal(t, "path", pathTag.Parameters[0].In)
+	assert.Equal(t, "integer", pathTag.Parameters[0].Schema.Type)
+	_, found = pathTag.Get.Responses[404]
+	assert.True(t, found)
+	_, found = pathTag.Delete.Responses[204]
+	assert.True(t, found)
+	assert.Equal(t, "Only allowed by authenticated users.", pathTag.Delete.Description)
 }
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/pkg/repo"
+	"gopkg.in/guregu/null.v4"
+	"strings"
+	"time"
+)
+
+type Tag struct {
+	ID      int         `json:"id" db:"id,pk,readonly" filter:"in" sort:"true"`
+	Created time.Time   `json:"created" db:"created,readonly" sort:"true"`
+	Name    string      `json:"name" db:"name" binding:"required" filter:"eq,like"`
+	Colour  null.String `json:"colour" db:"colour" filter:"eq,is_null"`
+}
+
+// buildTagRepo serves tags using a generated CRUD repo. Anyone can read tags,
+// but only authenticated users can modify them.
+func buildTagRepo() *repo.CRUD[Tag] {
+	r := repo.NewCRUD[Tag]("tags", "tags")
+	r.SetHooks(repo.Hooks[Tag]{
+		Authorize: func(c *gin.Context, op repo.Operation) bool {
+			return op == repo.List || op == repo.Get || c.GetHeader("auth") == "true"
+		},
+		Before: func(c *gin.Context, op repo.Operation, tag *Tag) bool {
+			if op == repo.Create || op == repo.Update {
+				tag.Name = strings.TrimSpace(tag.Name)
+			}
+			return true
+		},
+	})
+	r.Handler(repo.Delete).SetDescription("Only allowed by authenticated users.")
+	return r
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"github.com/kaphos/webapp"
+	"github.com/kaphos/webapp/pkg/db/dbtest"
+	"github.com/kaphos/webapp/pkg/pagination"
+	"github.com/kaphos/webapp/pkg/webapptest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestGetTags(t *testing.T) {
+	s, _ := setupDB(t)
+	client(t, s).GET("/api/tags/?filter[name][like]=Urg%25").
+		ExpectStatus(http.StatusOK).
+		ExpectJSONPath("data.0.name", "Urgent").
+		ExpectSnapshot("tags_urgent", "id")
+}
+
+func TestGetTagsSorted(t *testing.T) {
+	s, _ := setupDB(t)
+	var ascending, descending pagination.Response[Tag]
+	client(t, s).GET("/api/tags/?sort=id").ExpectStatus(http.StatusOK).DecodeJSON(&ascending)
+	client(t, s).GET("/api/tags/?sort=-id").ExpectStatus(http.StatusOK).DecodeJSON(&descending)
+
+	if assert.Greater(t, len(ascending.Data), 1) && assert.Len(t, descending.Data, len(ascending.Data)) {
+		for i, tag := range ascending.Data {
+			assert.Equal(t, tag.ID, descending.Data[len(descending.Data)-1-i].ID)
+		}
+	}
+}
+
+// TestGetTagsSortedWithFake checks that sort keys requested by the client order (and paginate)
+// the list, rather than being overridden by the primary key used for keyset pagination.
+func TestGetTagsSortedWithFake(t *testing.T) {
+	columns := []string{"id", "created", "name", "colour"}
+	created := time.Date(2023, 5, 21, 17, 32, 28, 0, time.UTC)
+
+	fake := dbtest.New()
+	fake.Expect(`SELECT * FROM (SELECT "id", "created", "name", "colour" FROM "tags") AS page
+		ORDER BY "created" DESC, "id" ASC LIMIT $1`).WithArgs(2).
+		WillReturnRows(columns,
+			[]interface{}{2, created, "Urgent", nil},
+			[]interface{}{1, created.Add(-time.Hour), "Someday", nil})
+	fake.Expect(`SELECT * FROM (SELECT "id", "created", "name", "colour" FROM "tags") AS page
+		WHERE ("created" < $1) OR ("created" = $1 AND "id" > $2)
+		ORDER BY "created" DESC, "id" ASC LIMIT $3`).WithArgs(dbtest.Any, "2", 2).
+		WillReturnRows(columns, []interface{}{1, created.Add(-time.Hour), "Someday", nil})
+
+	s, err := webapp.NewServer("Test App", "v1", "testuser", "testpass", 1, webapp.WithDatabase(fake))
+	if !assert.Nil(t, err) {
+		return
+	}
+	s.Attach(buildTagRepo())
+
+	var first, second pagination.Response[Tag]
+	client(t, &s).GET("/api/tags/?sort=-created&limit=1").ExpectStatus(http.StatusOK).DecodeJSON(&first)
+	if assert.Len(t, first.Data, 1) && assert.NotEmpty(t, first.Next) {
+		assert.Equal(t, 2, first.Data[0].ID)
+		client(t, &s).GET("/api/tags/?sort=-created&limit=1&cursor=" + first.Next).ExpectStatus(http.StatusOK).DecodeJSON(&second)
+	}
+	if assert.Len(t, second.Data, 1) {
+		assert.Equal(t, 1, second.Data[0].ID)
+	}
+
+	assert.Nil(t, fake.ExpectationsWereMet())
+}
+
+func TestTagLifecycle(t *testing.T) {
+	s, _ := setupDB(t)
+	var created Tag
+	client(t, s).POST("/api/tags/").WithAuth("true").WithJSON(webapptest.JSON{"name": "Someday"}).
+		ExpectStatus(http.StatusCreated).
+		ExpectJSONPath("name", "Someday").
+		DecodeJSON(&created)
+	if created.ID == 0 || created.Created.IsZero() {
+		t.Fatalf("expected the tag's ID and creation time to be set, got %+v", created)
+	}
+	path := fmt.Sprintf("/api/tags/%d", created.ID)
+
+	client(t, s).PUT(path).WithAuth("true").WithJSON(webapptest.JSON{"name": "Never"}).
+		ExpectStatus(http.StatusOK)
+	client(t, s).DELETE(path).WithAuth("true").
+		ExpectStatus(http.StatusNoContent)
+	client(t, s).GET(path).
+		ExpectStatus(http.StatusNotFound)
+}
+
+func TestTagInvalidID(t *testing.T) {
+	s, _ := setup()
+	client(t, s).GET("/api/tags/abc").
+		ExpectStatus(http.StatusNotFound)
+}
+
+func TestTagInvalidIDUnauthorised(t *testing.T) {
+	s, _ := setup()
+	client(t, s).PUT("/api/tags/abc").WithJSON(webapptest.JSON{"name": "Someday"}).
+		ExpectStatus(http.StatusForbidden)
+	client(t, s).DELETE("/api/tags/abc").
+		ExpectStatus(http.StatusForbidden)
+}
+
+func TestCreateTagUnauthorised(t *testing.T) {
+	s, _ := setup()
+	client(t, s).POST("/api/tags/").WithJSON(webapptest.JSON{"name": "Someday"}).
+		ExpectStatus(http.StatusForbidden)
+}
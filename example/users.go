@@ -3,52 +3,58 @@ package main
 import (
 	"context"
 	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/pkg/db"
+	"github.com/kaphos/webapp/pkg/filter"
 	"github.com/kaphos/webapp/pkg/handler"
+	"github.com/kaphos/webapp/pkg/pagination"
 	"github.com/kaphos/webapp/pkg/repo"
 	"net/http"
 )
 
 type User struct {
 	ID     int     `json:"id"`
-	Name   string  `json:"name" binding:"required" example:"John Doe"`
+	Name   string  `json:"name" binding:"required" example:"John Doe" filter:"eq,like"`
 	Email  string  `json:"email" binding:"required,email"`
-	Admin  bool    `json:"admin"`
-	Groups int     `json:"groups" example:"31"`
-	Age    float32 `json:"age"`
+	Admin  bool    `json:"admin" filter:"eq"`
+	Groups int     `json:"groups" example:"31" filter:"eq,in"`
+	Age    float32 `json:"age" filter:"lt,gte"`
 }
 
 type UserRepo struct{ repo.Repo[User] }
 
-func (r *UserRepo) dbCall(ctx context.Context) ([]User, error) {
-	rows, cancel, err := r.DB.Query("getUsers", ctx, `SELECT id, name, email, admin, groups, age FROM users`)
-	defer cancel()
-
-	if err != nil {
-		return nil, err
-	}
+var getUsers = db.Scanned[User](namedQueries, "getUsers")
 
-	users := make([]User, 0)
-	for rows.Next() {
-		var user User
-		err = rows.Scan(&user.ID, &user.Name, &user.Email, &user.Admin, &user.Groups, &user.Age)
-		if err != nil {
-			return nil, err
-		}
-		users = append(users, user)
-	}
+func (r *UserRepo) dbCall(ctx context.Context) ([]User, error) {
+	return db.QueryAll[User](r.DB, getUsers.Name, ctx, getUsers.SQL)
+}
 
-	return users, nil
+func (r *UserRepo) dbPage(ctx context.Context, filters filter.Query, page pagination.Page) ([]User, error) {
+	query, args := filters.Apply(`SELECT id, name, email, admin, groups, age FROM users`)
+	query, args = db.Paginate(query, page, args...)
+	return db.QueryAll[User](r.DB, "getUsersPage", ctx, query, args...)
 }
 
+var userPaginator = pagination.NewKeyset(false, "id")
+var userFilter = filter.For[User]()
+
 func (r *UserRepo) getAll(c *gin.Context) bool {
-	users, err := r.dbCall(c.Request.Context())
+	page, ok := userPaginator.Bind(c)
+	if !ok {
+		return false
+	}
+
+	filters, ok := userFilter.Bind(c)
+	if !ok {
+		return false
+	}
 
+	users, err := r.dbPage(c.Request.Context(), filters, page)
 	if err != nil {
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return false
 	}
 
-	c.JSON(http.StatusOK, users)
+	pagination.Respond(c, http.StatusOK, page, users, func(u User) []interface{} { return []interface{}{u.ID} })
 	return true
 }
 
@@ -61,7 +67,8 @@ func buildUserRepo() *UserRepo {
 	r := UserRepo{}
 	r.SetRelativePath("users")
 
-	getUsersHandler := handler.NewU("GET", "/", r.getAll, 200, make([]User, 0))
+	getUsersHandler := handler.NewU("GET", "/", r.getAll, 200, pagination.Response[User]{})
+	getUsersHandler.AddParamsFrom(userFilter)
 	r.AddHandler(&getUsersHandler)
 
 	addUserHandler := handler.NewP("POST", "/", r.fakeAdd, 201, 0)
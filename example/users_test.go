@@ -2,22 +2,31 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"github.com/kaphos/webapp"
+	"github.com/kaphos/webapp/pkg/db/dbtest"
+	"github.com/kaphos/webapp/pkg/db/fixtures"
+	"github.com/kaphos/webapp/pkg/pagination"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
 func TestGetUsers(t *testing.T) {
-	s, w := setup()
+	s, w := setupDB(t)
 	req, _ := http.NewRequest("GET", "/api/users/", nil)
 	s.Router.ServeHTTP(w, req)
 	assert.Equal(t, 200, w.Code)
 
-	var resp []User
+	var resp pagination.Response[User]
 	err := json.NewDecoder(w.Body).Decode(&resp)
 	assert.Nil(t, err)
-	assert.ElementsMatch(t, resp, []User{
+	assert.Empty(t, resp.Next)
+	assert.Empty(t, resp.Prev)
+	assert.ElementsMatch(t, resp.Data, []User{
 		{
 			ID:     1,
 			Name:   "John",
@@ -45,6 +54,104 @@ func TestGetUsers(t *testing.T) {
 	})
 }
 
+func TestGetUsersPaginated(t *testing.T) {
+	s, w := setupDB(t)
+	req, _ := http.NewRequest("GET", "/api/users/?limit=2", nil)
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var resp pagination.Response[User]
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	assert.Nil(t, err)
+	assert.Len(t, resp.Data, 2)
+	assert.NotEmpty(t, resp.Next)
+	assert.Contains(t, w.Header().Get("Link"), `rel="next"`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/users/?limit=2&cursor="+resp.Next, nil)
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	err = json.NewDecoder(w.Body).Decode(&resp)
+	assert.Nil(t, err)
+	assert.Len(t, resp.Data, 1)
+	assert.Empty(t, resp.Next)
+	assert.NotEmpty(t, resp.Prev)
+}
+
+func TestGetUsersFiltered(t *testing.T) {
+	s, w := setupDB(t)
+	req, _ := http.NewRequest("GET", "/api/users/?filter[admin]=false&filter[age][gte]=6", nil)
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var resp pagination.Response[User]
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	assert.Nil(t, err)
+	if assert.Len(t, resp.Data, 1) {
+		assert.Equal(t, "Jane", resp.Data[0].Name)
+	}
+}
+
+func TestGetUsersFromFixtures(t *testing.T) {
+	s, w := setupDB(t)
+	inserted, err := fixtures.MustLoad(fixtureFiles).Insert(context.Background(), s.DB)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	req, _ := http.NewRequest("GET", "/api/users/?filter[name]=Alice", nil)
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var resp pagination.Response[User]
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+	if assert.Len(t, resp.Data, 1) {
+		assert.Equal(t, inserted["users"]["alice"]["id"], int32(resp.Data[0].ID))
+	}
+}
+
+// TestGetUsersWithFake runs the users repo against a fake database, so runs without Postgres.
+func TestGetUsersWithFake(t *testing.T) {
+	t.Setenv("DB_CONNECT_RETRIES", "5") // not waited for, as repos use the fake
+	fake := dbtest.New()
+	fake.Expect(`SELECT * FROM (SELECT id, name, email, admin, groups, age FROM users) AS page
+		ORDER BY "id" ASC LIMIT $1`).WithArgs(21).
+		WillReturnRows([]string{"id", "name", "email", "admin", "groups", "age"},
+			[]interface{}{1, "John", "john@gmail.com", true, 1, 3.5})
+	fake.ExpectRegex(`FROM users`).WillReturnError(errors.New("connection lost"))
+
+	s, err := webapp.NewServer("Test App", "v1", "testuser", "testpass", 1, webapp.WithDatabase(fake))
+	if !assert.Nil(t, err) {
+		return
+	}
+	s.Attach(buildUserRepo())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/users/", nil)
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var resp pagination.Response[User]
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+	assert.Equal(t, []User{{ID: 1, Name: "John", Email: "john@gmail.com", Admin: true, Groups: 1, Age: 3.5}}, resp.Data)
+
+	w = httptest.NewRecorder()
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	assert.Nil(t, fake.ExpectationsWereMet())
+}
+
+func TestGetUsersInvalidQuery(t *testing.T) {
+	for _, query := range []string{"limit=1000", "filter[email]=a", "filter[age][gte]=old"} {
+		s, w := setup()
+		req, _ := http.NewRequest("GET", "/api/users/?"+query, nil)
+		s.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
 type AddUserTestCase struct {
 	name       string
 	body       []byte
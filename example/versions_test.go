@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp"
+	"github.com/kaphos/webapp/internal/swagger"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestVersions(t *testing.T) {
+	s := setupServer()
+
+	// v1 shares the ping handler, but responds in uppercase
+	pingRepo := buildPingRepo()
+	pingHandler := (*pingRepo.GetHandlers())[0]
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.Version("v1").
+		Sunset(sunset, "https://example.com/v1").
+		Transform(pingHandler, webapp.TransformResponse(func(c *gin.Context, status int, body []byte) []byte {
+			return bytes.ToUpper(body)
+		})).
+		Attach(pingRepo)
+	s.Version("v2").Attach(pingRepo)
+	s.Group("/public").Attach(pingRepo)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/ping/", nil)
+	s.Router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"PONG"`, w.Body.String())
+	assert.Equal(t, "Tue, 01 Jan 2030 00:00:00 GMT", w.Header().Get("Sunset"))
+	assert.Equal(t, `<https://example.com/v1>; rel="sunset"`, w.Header().Get("Link"))
+
+	for _, path := range []string{"/api/v2/ping/", "/public/ping/", "/api/ping/"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", path, nil)
+		s.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Empty(t, w.Header().Get("Sunset"), path)
+
+		var resp string
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal(t, "pong", resp)
+	}
+
+	filename := t.TempDir() + "/v1.yml"
+	assert.Nil(t, s.Version("v1").GenDocs(nil, filename))
+	yamlFile, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+
+	var api swagger.OpenAPI
+	assert.Nil(t, yaml.Unmarshal(yamlFile, &api))
+	assert.Equal(t, "v1", api.Info.Version)
+	assert.True(t, api.Paths["/ping/"].Get.Deprecated)
+	_, found := api.Paths["/items/"]
+	assert.False(t, found)
+}
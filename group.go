@@ -0,0 +1,249 @@
+package webapp
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/internal/httpbase"
+	"github.com/kaphos/webapp/internal/swagger"
+	"github.com/kaphos/webapp/pkg/db"
+	"github.com/kaphos/webapp/pkg/repo"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Group is a set of repos mounted under a common base path (e.g. "/api/v2"), documented
+// in its own OpenAPI document. Server.Attach attaches repos to the default "/api" group;
+// further groups can be created using Server.Version and Server.Group.
+type Group struct {
+	logger     *zap.Logger
+	database   db.DB
+	router     *gin.RouterGroup
+	apiDocs    *swagger.OpenAPI
+	transforms map[httpbase.HandlerBaseI][]gin.HandlerFunc
+	sunset     *time.Time
+	sunsetLink string
+}
+
+func newGroup(logger *zap.Logger, database db.DB, router *gin.RouterGroup, apiDocs *swagger.OpenAPI) *Group {
+	g := &Group{
+		logger:     logger,
+		database:   database,
+		router:     router,
+		apiDocs:    apiDocs,
+		transforms: make(map[httpbase.HandlerBaseI][]gin.HandlerFunc),
+	}
+	router.Use(g.sunsetMiddleware)
+	return g
+}
+
+// Version returns the group for the given API version (e.g. "v2"), served under
+// "/api/<version>", creating it if it does not already exist.
+func (s *Server) Version(version string) *Group {
+	path := "/" + strings.Trim(version, "/")
+	if g, ok := s.groups["/api"+path]; ok {
+		return g
+	}
+
+	apiDocs := swagger.Generate(s.appName, version)
+	g := newGroup(s.logger, s.repoDB, s.api.router.Group(path), &apiDocs)
+	s.groups["/api"+path] = g
+	return g
+}
+
+// Group returns a group served under the given path outside of "/api" (e.g. "/callbacks"
+// or "/"), creating it if it does not already exist. Useful for public pages and callbacks.
+func (s *Server) Group(path string) *Group {
+	path = "/" + strings.Trim(path, "/")
+	if g, ok := s.groups[path]; ok {
+		return g
+	}
+
+	apiDocs := swagger.Generate(s.appName, s.version)
+	g := newGroup(s.logger, s.repoDB, s.Router.Group(path, s.loggerMiddleware, s.lifecycleMiddleware), &apiDocs)
+	s.groups[path] = g
+	return g
+}
+
+// BasePath returns the path the group is served under (e.g. "/api/v2").
+func (g *Group) BasePath() string {
+	return g.router.BasePath()
+}
+
+// Sunset marks the group as retired. Every response from the group will include a Sunset
+// header with the given date (RFC 8594), along with a Link header to the given URL (if
+// provided) for more information, and its operations will be documented as deprecated.
+func (g *Group) Sunset(date time.Time, link string) *Group {
+	g.sunset = &date
+	g.sunsetLink = link
+	g.markDeprecated()
+	return g
+}
+
+// markDeprecated marks all operations documented so far as deprecated.
+func (g *Group) markDeprecated() {
+	for _, val := range g.apiDocs.Paths {
+		for _, operation := range []*swagger.Operation{val.Get, val.Put, val.Post, val.Delete} {
+			if operation != nil {
+				operation.Deprecated = true
+			}
+		}
+	}
+}
+
+func (g *Group) sunsetMiddleware(c *gin.Context) {
+	if g.sunset != nil {
+		c.Header("Deprecation", "true")
+		c.Header("Sunset", g.sunset.UTC().Format(http.TimeFormat))
+		if g.sunsetLink != "" {
+			c.Header("Link", "<"+g.sunsetLink+">; rel=\"sunset\"")
+		}
+	}
+	c.Next()
+}
+
+// Transform registers functions to run before h, only when h is served from this group.
+// This allows a handler to be shared across versions, with older versions adapting the
+// request (or, using TransformResponse, the response) as needed. Should be called
+// before the repo containing h is attached.
+func (g *Group) Transform(h httpbase.HandlerBaseI, fns ...gin.HandlerFunc) *Group {
+	g.transforms[h] = append(g.transforms[h], fns...)
+	return g
+}
+
+// TransformResponse returns a transform (see Group.Transform) that buffers the handler's
+// response, and replaces its body with the result of fn. Not suitable for streamed
+// responses (e.g. Server-Sent Events or WebSockets).
+func TransformResponse(fn func(c *gin.Context, status int, body []byte) []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		original := c.Writer
+		buffered := httpbase.NewBufferedWriter(original)
+		c.Writer = buffered
+		c.Next()
+		c.Writer = original
+
+		body := fn(c, original.Status(), buffered.Body())
+		original.Header().Del("Content-Length")
+		_, _ = original.Write(body)
+	}
+}
+
+func (g *Group) addAPIPath(r repo.RepoI, h httpbase.HandlerBaseI, tag, path string, inherited parentDocs) {
+	// Build the list of potential responses by the parent repos, the repo and handlers.
+	responses := make(map[int]swagger.Response)
+
+	for code, resp := range inherited.responses {
+		responses[code] = resp
+	}
+
+	for code, resp := range r.Responses() {
+		responses[code] = resp
+	}
+
+	for code, resp := range h.Responses() { // Handler after repo, to overwrite anything that may have been declared
+		responses[code] = resp
+	}
+
+	authGroups := append(make([]string, 0), inherited.authGroups...)
+	authGroups = append(authGroups, r.AuthGroups()...)
+	authGroups = append(authGroups, h.AuthGroups()...)
+
+	params := make(map[string]swagger.SimpleParam)
+	for name, param := range inherited.params {
+		params[name] = param
+	}
+	for name, param := range h.Params() {
+		params[name] = param
+	}
+
+	g.apiDocs.AddPath(h.Type(), tag, h.Method(), path, h.Summary(), h.Description(), params, authGroups, responses)
+	if g.sunset != nil {
+		g.markDeprecated()
+	}
+}
+
+// parentDocs tracks documentation inherited by a child repo from its parent repos.
+type parentDocs struct {
+	tag        string
+	path       string
+	params     map[string]swagger.SimpleParam
+	responses  map[int]swagger.Response
+	authGroups []string
+}
+
+// Attach a Repo to the group. Initialises the repository by passing in the database connection
+// and a tracer object, and adds each of the repository's handlers (and those of its child repos)
+// to the group's router.
+func (g *Group) Attach(r repo.RepoI) {
+	g.attach(r, g.router, parentDocs{}, make([]gin.HandlerFunc, 0))
+}
+
+// attach adds r's handlers under parent. If r is a child repo, resolvers for its parents (if any)
+// are run after all middleware, so that e.g. authentication is checked before parents are looked up.
+func (g *Group) attach(r repo.RepoI, parent *gin.RouterGroup, inherited parentDocs, resolvers []gin.HandlerFunc) {
+	g.logger.Debug(fmt.Sprintf("Attaching repo \"%s\" under \"%s\"", r.RelativePath(), parent.BasePath()))
+	r.Init(g.database)
+
+	group := parent.Group(r.RelativePath(), *r.Middleware()...)
+
+	tag := r.RelativePath()
+	if inherited.tag != "" {
+		tag = inherited.tag + "/" + tag
+	}
+	prefix := inherited.path + "/" + r.RelativePath()
+
+	for _, h := range *r.GetHandlers() {
+		path := buildPath(prefix, h)
+		g.logger.Debug(fmt.Sprintf(" - Attaching handler at \"%s\" (%s)", path, h.Method()))
+
+		handlers := make([]gin.HandlerFunc, 0)
+		handlers = append(handlers, *h.Middleware()...)
+		handlers = append(handlers, g.transforms[h]...)
+		handlers = append(handlers, resolvers...)
+		handlers = append(handlers, h.Handle)
+		group.Handle(h.Method(), h.RelativePath(), handlers...)
+
+		// Build Swagger API
+		g.addAPIPath(r, h, tag, path, inherited)
+	}
+
+	for _, sub := range r.SubRepos() {
+		childDocs := parentDocs{
+			tag:        tag,
+			path:       prefix + "/:" + sub.Param,
+			params:     make(map[string]swagger.SimpleParam),
+			responses:  make(map[int]swagger.Response),
+			authGroups: append(append(make([]string, 0), inherited.authGroups...), r.AuthGroups()...),
+		}
+		for name, param := range inherited.params {
+			childDocs.params[name] = param
+		}
+		childDocs.params[sub.Param] = swagger.SimpleParam{Type: "string", Description: "Identifies the parent " + tag + " entry"}
+		for code, resp := range inherited.responses {
+			childDocs.responses[code] = resp
+		}
+		for code, resp := range r.Responses() {
+			childDocs.responses[code] = resp
+		}
+
+		childResolvers := append(make([]gin.HandlerFunc, 0), resolvers...)
+		if resolver := r.ParentResolver(sub.Param); resolver != nil {
+			childResolvers = append(childResolvers, resolver)
+			childDocs.responses[http.StatusNotFound] = swagger.Response{Description: "Parent " + tag + " entry not found"}
+		}
+
+		g.attach(sub.Repo, group.Group("/:"+sub.Param), childDocs, childResolvers)
+	}
+}
+
+// GenDocs writes the group's OpenAPI documentation at the provided filename (JSON or YAML).
+// "servers" is used just to decorate the file (as part of the OpenAPI spec,
+// rather than being functional).
+func (g *Group) GenDocs(servers []APIServer, filename string) error {
+	for _, server := range servers {
+		g.apiDocs.AddServer(server.URL, server.Description)
+	}
+
+	return g.apiDocs.Write(filename)
+}
@@ -0,0 +1,9 @@
+// Package apidocs gives the webapptest package access to a server's OpenAPI documentation,
+// without it being part of the webapp package's API.
+package apidocs
+
+import "github.com/kaphos/webapp/internal/swagger"
+
+// Of returns the documentation of each of a *webapp.Server's groups (including the default
+// "/api" group), keyed by base path. Set by the webapp package, which cannot be imported here.
+var Of func(server interface{}) map[string]*swagger.OpenAPI
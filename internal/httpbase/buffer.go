@@ -0,0 +1,27 @@
+package httpbase
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+)
+
+// BufferedWriter holds back the response body (and status) written by later handlers, so
+// that it can be transformed or discarded before being sent. The status and headers are
+// still set on the wrapped ResponseWriter, but are not written until it is written to.
+type BufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// NewBufferedWriter wraps w; it should be swapped in as the context's Writer.
+func NewBufferedWriter(w gin.ResponseWriter) *BufferedWriter {
+	return &BufferedWriter{ResponseWriter: w}
+}
+
+// Body returns the response body written so far.
+func (w *BufferedWriter) Body() []byte { return w.body.Bytes() }
+
+func (w *BufferedWriter) Write(data []byte) (int, error)       { return w.body.Write(data) }
+func (w *BufferedWriter) WriteString(data string) (int, error) { return w.body.WriteString(data) }
+func (w *BufferedWriter) WriteHeaderNow()                      {}
+func (w *BufferedWriter) Written() bool                        { return false }
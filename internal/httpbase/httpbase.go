@@ -44,13 +44,18 @@ func (f *HTTPBase) SetMiddleware(middleware ...middleware.Middleware) {
 
 	for _, m := range middleware {
 		// Process and add the middleware
-		f.middleware = append(f.middleware, func(c *gin.Context) {
-			if ok := m.Fn(c); !ok {
-				c.AbortWithStatus(m.FailStatusCode)
-			} else {
-				c.Next()
+		handler := m.Handler
+		if handler == nil {
+			fn, failStatusCode := m.Fn, m.FailStatusCode
+			handler = func(c *gin.Context) {
+				if ok := fn(c); !ok {
+					c.AbortWithStatus(failStatusCode)
+				} else {
+					c.Next()
+				}
 			}
-		})
+		}
+		f.middleware = append(f.middleware, handler)
 
 		f.SetResponse(m.FailStatusCode, m.FailResponse)
 		f.authGroups = append(f.authGroups, m.AuthGroups...)
@@ -0,0 +1,68 @@
+package httpbase
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/pkg/db"
+)
+
+const (
+	shutdownKey  = "kphs.shutdown"
+	longLivedKey = "kphs.longLived"
+	databaseKey  = "kphs.database"
+	resultKey    = "kphs.result"
+)
+
+// SetShutdownContext stores the server's shutdown context in the request. Called by the
+// Server for every request, so that long-lived handlers can stop when the server shuts down.
+func SetShutdownContext(c *gin.Context, ctx context.Context) {
+	c.Set(shutdownKey, ctx)
+}
+
+// ShutdownContext returns a context that is cancelled once the server begins shutting down.
+// If the request did not go through the Server (e.g. in a standalone Gin engine), a context
+// that is never cancelled is returned instead.
+func ShutdownContext(c *gin.Context) context.Context {
+	if val, ok := c.Get(shutdownKey); ok {
+		return val.(context.Context)
+	}
+	return context.Background()
+}
+
+// SetLongLived marks the request as a long-lived connection of the given kind (e.g. "sse"),
+// so that it is tracked separately from regular request/response latencies.
+func SetLongLived(c *gin.Context, kind string) {
+	c.Set(longLivedKey, kind)
+}
+
+// LongLived returns the kind of long-lived connection the request was marked as, if any.
+func LongLived(c *gin.Context) (string, bool) {
+	kind := c.GetString(longLivedKey)
+	return kind, kind != ""
+}
+
+// SetDatabase stores the database passed to repos in the request. Called by the Server for
+// every request, so that middleware (e.g. handler.WithTx) can use it without being initialised.
+func SetDatabase(c *gin.Context, database db.DB) {
+	c.Set(databaseKey, database)
+}
+
+// Database returns the database stored in the request, or nil if the request
+// did not go through the Server.
+func Database(c *gin.Context) db.DB {
+	if val, ok := c.Get(databaseKey); ok {
+		return val.(db.DB)
+	}
+	return nil
+}
+
+// SetResult records whether the handler function was successful (i.e. returned true).
+func SetResult(c *gin.Context, ok bool) {
+	c.Set(resultKey, ok)
+}
+
+// Result returns whether the handler function was successful, and false if it did not run
+// (e.g. if the request was rejected by middleware).
+func Result(c *gin.Context) bool {
+	return c.GetBool(resultKey)
+}
@@ -0,0 +1,117 @@
+// Package structmap maps struct fields to database columns, for use by the packages that
+// build or scan SQL from structs. A field's column is taken from its `db` tag, falling back
+// to its `json` tag and then its name. Options can follow the column name in the `db` tag
+// (e.g. `db:"id,pk,readonly"`), and `db:"-"` excludes a field. Embedded structs are flattened.
+package structmap
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Field describes a single struct field mapped to a column.
+type Field struct {
+	Name     string       // Go field name
+	Index    []int        // index sequence for reflect.Value.FieldByIndex
+	Column   string       // database column
+	JSONName string       // name of the field in JSON payloads
+	Options  []string     // options following the column name in the `db` tag
+	Type     reflect.Type // type of the field
+	Tag      reflect.StructTag
+}
+
+// HasOption returns true if the option (e.g. "pk") was set in the field's `db` tag.
+func (f Field) HasOption(option string) bool {
+	for _, o := range f.Options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+var cache sync.Map // reflect.Type -> []Field
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// Fields returns the mapped fields of a struct type, in declaration order.
+// Panics if t is not a struct type.
+func Fields(t reflect.Type) []Field {
+	if cached, ok := cache.Load(t); ok {
+		return cached.([]Field)
+	}
+
+	if t.Kind() != reflect.Struct {
+		panic("structmap: type '" + t.String() + "' is not a struct")
+	}
+
+	fields := collect(t, nil)
+	cache.Store(t, fields)
+	return fields
+}
+
+// Of is a shortcut for Fields(reflect.TypeOf(*new(T))).
+func Of[T any]() []Field {
+	return Fields(reflect.TypeOf(*new(T)))
+}
+
+// ByColumn returns the fields of a struct type, keyed by column.
+func ByColumn(t reflect.Type) map[string]Field {
+	byColumn := make(map[string]Field)
+	for _, f := range Fields(t) {
+		byColumn[f.Column] = f
+	}
+	return byColumn
+}
+
+func collect(t reflect.Type, parentIndex []int) []Field {
+	fields := make([]Field, 0, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		index := append(append(make([]int, 0, len(parentIndex)+1), parentIndex...), i)
+
+		dbTag, hasDBTag := sf.Tag.Lookup("db")
+		column, options, _ := strings.Cut(dbTag, ",")
+		jsonName, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+
+		if column == "-" || (!hasDBTag && jsonName == "-") {
+			continue
+		}
+
+		// Flatten embedded structs, unless they are scannable types in their own right
+		if sf.Anonymous && !hasDBTag && sf.Type.Kind() == reflect.Struct && !reflect.PointerTo(sf.Type).Implements(scannerType) {
+			fields = append(fields, collect(sf.Type, index)...)
+			continue
+		}
+
+		if !sf.IsExported() {
+			continue
+		}
+
+		if jsonName == "" || jsonName == "-" {
+			jsonName = sf.Name
+		}
+		if column == "" {
+			column = jsonName
+		}
+
+		var opts []string
+		if options != "" {
+			opts = strings.Split(options, ",")
+		}
+
+		fields = append(fields, Field{
+			Name:     sf.Name,
+			Index:    index,
+			Column:   column,
+			JSONName: jsonName,
+			Options:  opts,
+			Type:     sf.Type,
+			Tag:      sf.Tag,
+		})
+	}
+
+	return fields
+}
@@ -0,0 +1,110 @@
+package structmap
+
+import (
+	"errors"
+	"github.com/gofrs/uuid/v5"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kind is the kind of value a field holds, used to validate and convert user input (e.g.
+// query string or path parameters) before it is passed to the database.
+type Kind int
+
+const (
+	StringKind Kind = iota
+	IntKind
+	FloatKind
+	BoolKind
+	TimeKind
+	UUIDKind
+)
+
+// KindOf returns the Kind of value held by a field of the given type.
+func KindOf(t reflect.Type) Kind {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.String() {
+	case "time.Time", "null.Time":
+		return TimeKind
+	case "uuid.UUID", "uuid.NullUUID":
+		return UUIDKind
+	case "null.Int":
+		return IntKind
+	case "null.Float":
+		return FloatKind
+	case "null.Bool":
+		return BoolKind
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return IntKind
+	case reflect.Float32, reflect.Float64:
+		return FloatKind
+	case reflect.Bool:
+		return BoolKind
+	}
+
+	return StringKind
+}
+
+// Parse converts raw user input into a value of this Kind, returning a user-friendly
+// error if it is not valid.
+func (k Kind) Parse(raw string) (interface{}, error) {
+	switch k {
+	case IntKind:
+		val, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, errors.New("must be an integer")
+		}
+		return val, nil
+	case FloatKind:
+		val, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, errors.New("must be a number")
+		}
+		return val, nil
+	case BoolKind:
+		val, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, errors.New("must be true or false")
+		}
+		return val, nil
+	case TimeKind:
+		val, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			val, err = time.Parse("2006-01-02", raw)
+		}
+		if err != nil {
+			return nil, errors.New("must be a date or RFC 3339 timestamp")
+		}
+		return val, nil
+	case UUIDKind:
+		val, err := uuid.FromString(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, errors.New("must be a UUID")
+		}
+		return val, nil
+	}
+
+	return raw, nil
+}
+
+// SwaggerType returns the OpenAPI type for values of this Kind.
+func (k Kind) SwaggerType() string {
+	switch k {
+	case IntKind:
+		return "integer"
+	case FloatKind:
+		return "number"
+	case BoolKind:
+		return "boolean"
+	}
+	return "string"
+}
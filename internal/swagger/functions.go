@@ -42,6 +42,12 @@ func (o *OpenAPI) AddServer(url, description string) {
 // object, given an interface. Automatically sets it to "application/json"
 // content type.
 func GenContent(t interface{}, hideEmptyBind bool) (*map[string]MediaType, []Parameter) {
+	return GenContentType(t, "application/json", hideEmptyBind)
+}
+
+// GenContentType is similar to GenContent, but allows the media type to be specified
+// (e.g. "text/event-stream" for streamed responses).
+func GenContentType(t interface{}, mediaType string, hideEmptyBind bool) (*map[string]MediaType, []Parameter) {
 	if t == nil {
 		return nil, make([]Parameter, 0)
 	}
@@ -49,7 +55,7 @@ func GenContent(t interface{}, hideEmptyBind bool) (*map[string]MediaType, []Par
 	schema, queryParams := genSchema(reflect.TypeOf(t), hideEmptyBind)
 
 	return &map[string]MediaType{
-		"application/json": {
+		mediaType: {
 			Schema: schema,
 		},
 	}, queryParams
@@ -1,11 +1,18 @@
 package swagger
 
+// ParamDocumenter is implemented by types that rely on query parameters (e.g. pagination),
+// so that the parameters can be documented automatically.
+type ParamDocumenter interface {
+	SwaggerParams() map[string]SimpleParam
+}
+
 type HandlerI interface {
 	SetSummary(string)
 	Summary() string
 	SetDescription(string)
 	Description() string
 	AddParam(string, string, string)
+	RemoveParam(string)
 	Params() map[string]SimpleParam
 	AddResponse(int, string, interface{})
 	AddResponses(...int)
@@ -49,6 +56,9 @@ func (f *Handler) AddParam(name, varType, description string) {
 	f.parameters[name] = SimpleParam{Type: varType, Description: description}
 }
 
+// RemoveParam removes a previously added parameter, e.g. if a path parameter is renamed.
+func (f *Handler) RemoveParam(name string) { delete(f.parameters, name) }
+
 func (f *Handler) Params() map[string]SimpleParam { return f.parameters }
 
 // Responses returns the list of responses the Handler may return.
@@ -63,6 +73,8 @@ func (f *Handler) SetResponse(statusCode int, resp Response) {
 
 // AddResponse adds a single Swagger response into this Handler. Also supports
 // tracking an expected response content, though this is not enforced or checked.
+// If the payload implements ParamDocumenter (e.g. a paginated response), the query
+// parameters it relies on are documented as well.
 func (f *Handler) AddResponse(statusCode int, description string, payload interface{}) {
 	resp := Response{Description: description}
 
@@ -73,6 +85,35 @@ func (f *Handler) AddResponse(statusCode int, description string, payload interf
 	}
 
 	f.responses[statusCode] = resp
+
+	if documenter, ok := payload.(ParamDocumenter); ok {
+		f.AddParamsFrom(documenter)
+	}
+}
+
+// AddParamsFrom documents the query parameters declared by a ParamDocumenter
+// (e.g. a filter specification), along with the 400 response returned if they are invalid.
+func (f *Handler) AddParamsFrom(documenter ParamDocumenter) {
+	for name, param := range documenter.SwaggerParams() {
+		f.AddParam(name, param.Type, param.Description)
+	}
+
+	if _, ok := f.responses[400]; !ok {
+		f.AddResponses(400)
+	}
+}
+
+// AddContentResponse is similar to AddResponse, but documents the payload under the given
+// media type instead of "application/json".
+func (f *Handler) AddContentResponse(statusCode int, description, mediaType string, payload interface{}) {
+	resp := Response{Description: description}
+
+	if payload != nil {
+		content, _ := GenContentType(payload, mediaType, false)
+		resp.Content = *content
+	}
+
+	f.responses[statusCode] = resp
 }
 
 var ResponseDescriptions = map[int]string{
@@ -81,6 +122,7 @@ var ResponseDescriptions = map[int]string{
 	400: "Invalid request body", // automatically added for handlers with payloads
 	401: "Unauthorised",
 	500: "Internal server error", // automatically added for all handlers
+	504: "Timed out",
 }
 
 // AddResponses is a helper function to bulk-add a series of "standard" responses.
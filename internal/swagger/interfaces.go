@@ -50,6 +50,7 @@ type Operation struct {
 	RequestBody *RequestBody          `json:"requestBody,omitempty" yaml:"requestBody,omitempty"`
 	Security    []map[string][]string `json:"security"`
 	Responses   map[int]Response      `json:"responses,omitempty" yaml:"responses,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
 }
 
 type RequestBody struct {
@@ -7,7 +7,7 @@ import (
 	"strings"
 )
 
-var pathParamRegexp = regexp.MustCompile(":([a-z]*)")
+var pathParamRegexp = regexp.MustCompile(":([a-zA-Z0-9_]*)")
 
 func processPath(path string) (string, []string) {
 	matches := pathParamRegexp.FindAllStringSubmatch(path, -1)
@@ -127,7 +127,7 @@ func genSchema(reflected reflect.Type, hideEmptyBind bool) (Schema, []Parameter)
 		schemaProperty := Schema{}
 		field := reflected.Field(i)
 
-		fieldName := field.Tag.Get("json")
+		fieldName, _, _ := strings.Cut(field.Tag.Get("json"), ",") // ignore options such as omitempty
 		if fieldName == "" {
 			fieldName = field.Tag.Get("form")
 		}
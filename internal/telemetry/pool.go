@@ -0,0 +1,72 @@
+package telemetry
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+)
+
+// poolCollector exports the statistics of each registered database pool.
+type poolCollector struct {
+	mu    sync.Mutex
+	pools map[string]func() *pgxpool.Stat
+}
+
+var pools = &poolCollector{pools: make(map[string]func() *pgxpool.Stat)}
+
+func init() {
+	prometheus.MustRegister(pools)
+}
+
+var (
+	poolLabels = []string{"pool"}
+
+	poolAcquiredConns = prometheus.NewDesc("kphs_db_pool_acquired_conns",
+		"The number of connections currently in use", poolLabels, nil)
+	poolIdleConns = prometheus.NewDesc("kphs_db_pool_idle_conns",
+		"The number of idle connections in the pool", poolLabels, nil)
+	poolTotalConns = prometheus.NewDesc("kphs_db_pool_total_conns",
+		"The total number of connections in the pool, including ones being established", poolLabels, nil)
+	poolMaxConns = prometheus.NewDesc("kphs_db_pool_max_conns",
+		"The maximum number of connections in the pool", poolLabels, nil)
+	poolAcquires = prometheus.NewDesc("kphs_db_pool_acquires_total",
+		"The number of successful connection acquires from the pool", poolLabels, nil)
+	poolAcquireDuration = prometheus.NewDesc("kphs_db_pool_acquire_duration_seconds_total",
+		"The total time spent acquiring connections from the pool", poolLabels, nil)
+	poolEmptyAcquires = prometheus.NewDesc("kphs_db_pool_empty_acquires_total",
+		"The number of acquires that had to wait for a connection, as the pool was empty", poolLabels, nil)
+	poolCanceledAcquires = prometheus.NewDesc("kphs_db_pool_canceled_acquires_total",
+		"The number of acquires cancelled by their context", poolLabels, nil)
+)
+
+// RegisterPool exports the statistics of a database pool under the given name (e.g.
+// "primary"), replacing any pool previously registered under the same name.
+func RegisterPool(name string, stat func() *pgxpool.Stat) {
+	pools.mu.Lock()
+	defer pools.mu.Unlock()
+	pools.pools[name] = stat
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{poolAcquiredConns, poolIdleConns, poolTotalConns, poolMaxConns,
+		poolAcquires, poolAcquireDuration, poolEmptyAcquires, poolCanceledAcquires} {
+		ch <- desc
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, statFn := range c.pools {
+		stat := statFn()
+		ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()), name)
+		ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(poolAcquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(poolCanceledAcquires, prometheus.CounterValue, float64(stat.CanceledAcquireCount()), name)
+	}
+}
@@ -19,15 +19,23 @@ var (
 		MaxAge:     time.Hour * 24 * 21,
 	}, []string{"method", "status"})
 
-	// SQLLatency tracks the amount of time taken for each SQL query
-	// to complete.
-	SQLLatency = promauto.NewSummaryVec(prometheus.SummaryOpts{
-		Namespace:  "kphs",
-		Name:       "sql_latency_ms",
-		Help:       "The time taken to perform a database query",
-		Objectives: map[float64]float64{0.5: 5, 0.75: 2.5, 0.9: 1, 0.95: 0.5, 0.99: 0.1},
-		MaxAge:     time.Hour * 24 * 21,
-	}, []string{"method"})
+	// SQLDuration tracks the amount of time taken for each SQL query to complete,
+	// by the span name given to the query, the type of operation, its outcome
+	// (ok, no_rows, client_error or error) and the pool it ran on.
+	SQLDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kphs",
+		Name:      "sql_duration_seconds",
+		Help:      "The time taken to perform a database query",
+		Buckets:   []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"span", "operation", "outcome", "pool"})
+
+	// DBPoolHealthy tracks whether each read replica is currently
+	// healthy (1), or has been ejected (0).
+	DBPoolHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kphs",
+		Name:      "db_pool_healthy",
+		Help:      "Whether a database pool is currently healthy and receiving queries",
+	}, []string{"pool"})
 
 	// ErrCheckCount counts the number of times errchk
 	// was used to check if there is an error. This can be used
@@ -47,6 +55,40 @@ var (
 		Help:      "The number of times that return values checked were actually errors",
 	})
 
+	// StreamsActive tracks the number of long-lived connections (e.g. Server-Sent
+	// Events) that are currently open.
+	StreamsActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kphs",
+		Name:      "streams_active",
+		Help:      "The number of long-lived connections currently open",
+	}, []string{"type"})
+
+	// StreamsDuration tracks how long long-lived connections stay open. These
+	// are kept out of RequestsLatency, as they would otherwise skew it.
+	StreamsDuration = promauto.NewSummaryVec(prometheus.SummaryOpts{
+		Namespace:  "kphs",
+		Name:       "streams_duration_seconds",
+		Help:       "The time a long-lived connection was kept open",
+		Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		MaxAge:     time.Hour * 24 * 21,
+	}, []string{"type", "status"})
+
+	// StreamMessages counts the number of messages sent or received
+	// over long-lived connections.
+	StreamMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kphs",
+		Name:      "stream_messages_total",
+		Help:      "The number of messages sent or received over long-lived connections",
+	}, []string{"type", "direction"})
+
+	// DBNotifications counts the number of LISTEN/NOTIFY notifications
+	// sent or received, by channel.
+	DBNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kphs",
+		Name:      "db_notifications_total",
+		Help:      "The number of database notifications sent or received",
+	}, []string{"channel", "direction"})
+
 	PromHandler = promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{})
 )
 
@@ -57,8 +99,18 @@ func PromLogRequest(method, status string, latencySeconds float64) {
 	}).Observe(latencySeconds * 100)
 }
 
-func PromLogSQL(method string, latencySeconds float64) {
-	SQLLatency.With(prometheus.Labels{
-		"method": method,
-	}).Observe(latencySeconds * 100)
+func PromLogSQL(span, operation, outcome, pool string, latencySeconds float64) {
+	SQLDuration.With(prometheus.Labels{
+		"span":      span,
+		"operation": operation,
+		"outcome":   outcome,
+		"pool":      pool,
+	}).Observe(latencySeconds)
+}
+
+func PromLogStream(streamType, status string, latencySeconds float64) {
+	StreamsDuration.With(prometheus.Labels{
+		"type":   streamType,
+		"status": status,
+	}).Observe(latencySeconds)
 }
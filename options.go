@@ -0,0 +1,61 @@
+package webapp
+
+import (
+	"github.com/kaphos/webapp/pkg/db"
+	"github.com/kaphos/webapp/pkg/db/fixtures"
+	"github.com/kaphos/webapp/pkg/db/migrate"
+	"io/fs"
+)
+
+// ServerOption configures optional behaviour of a Server, and is passed to NewServer.
+type ServerOption func(s *Server) error
+
+// WithMigrations registers the SQL migrations in fsys (typically an embed.FS; see the
+// migrate package for file naming). Once registered, Start refuses to run if the database
+// is incompatible with them, they can be run using the "migrate" command (see Run), and,
+// if MIGRATE_ON_START is set to "true", they are applied by NewServer.
+func WithMigrations(fsys fs.FS) ServerOption {
+	return func(s *Server) error {
+		migrator, err := migrate.New(fsys)
+		if err != nil {
+			return err
+		}
+		s.migrator = migrator
+		return nil
+	}
+}
+
+// WithQueries registers a set of named queries (see db.LoadQueries). Once registered, Start
+// prepares each of them against the database, and refuses to run if any fail to prepare, or
+// cannot be scanned into the targets registered for them using db.Scanned.
+func WithQueries(queries *db.Queries) ServerOption {
+	return func(s *Server) error {
+		s.queries = queries
+		return nil
+	}
+}
+
+// WithFixtures registers the fixture files in fsys (typically an embed.FS; see the fixtures
+// package), so that they can be loaded using the "seed" command (see Run).
+func WithFixtures(fsys fs.FS) ServerOption {
+	return func(s *Server) error {
+		f, err := fixtures.Load(fsys)
+		if err != nil {
+			return err
+		}
+		s.fixtures = f
+		return nil
+	}
+}
+
+// WithDatabase passes database to repos when they are attached, instead of the Server's own
+// Database, e.g. to test repos against a fake (see the dbtest package) without Postgres.
+// The Server's Database is then not waited for (see db.NewLazyDB), and is still used for
+// everything else (e.g. healthchecks and migrations). Handlers using handler.WithTx respond
+// with a 500 unless database is a *db.Database, as their transactions would not be used.
+func WithDatabase(database db.DB) ServerOption {
+	return func(s *Server) error {
+		s.repoDB = database
+		return nil
+	}
+}
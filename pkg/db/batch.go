@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/kaphos/webapp/pkg/errchk"
+	"strings"
+	"time"
+)
+
+const opBatch = "batch"
+
+// Batch queues statements to be sent to the database in a single round trip (see SendBatch
+// and ExecBatch), instead of calling Exec once per statement.
+type Batch struct {
+	batch   pgx.Batch
+	queries []string
+}
+
+// Queue adds a statement to the batch.
+func (b *Batch) Queue(query string, args ...interface{}) {
+	b.batch.Queue(query, args...)
+	b.queries = append(b.queries, query)
+}
+
+// Len returns the number of statements queued.
+func (b *Batch) Len() int { return b.batch.Len() }
+
+// BatchResults reads the result of each statement of a batch, in the order they were
+// queued. Must be closed once done, even if not all results are read.
+type BatchResults struct {
+	spanName string
+	results  pgx.BatchResults
+	end      func(rows int64, err error)
+	rows     int64
+	err      error // first error encountered, before conversion
+	closed   bool
+}
+
+// SendBatch sends all the statements of a batch in a single round trip, within a single span
+// and timeout (see WithTimeout to extend it for large batches). Always runs on the primary
+// (or within the transaction carried by ctx). Any errors encountered internally are
+// automatically handled using the errorhandling package.
+func (d *Database) SendBatch(spanName string, parentCtx context.Context, b *Batch) *BatchResults {
+	start := time.Now()
+	spanCtx, span := d.tracer.Start(parentCtx, spanName)
+	ctx, cancel := d.withTimeout(spanCtx)
+
+	q := d.primary(ctx)
+	markWrite(ctx)
+	results := q.SendBatch(ctx, &b.batch)
+
+	// Logged as one query, but not explained: the statements would need their arguments, and
+	// could run a second time
+	query := strings.Join(b.queries, ";\n")
+	end := func(rows int64, err error) {
+		cancel()
+		d.observe(spanCtx, span, execution{spanName, opBatch, primaryPool, nil, query, nil, start, rows, err})
+		span.End()
+	}
+
+	return &BatchResults{spanName: spanName, results: results, end: end}
+}
+
+// ExecBatch sends all the statements of a batch in a single round trip, ignoring their
+// results. Returns the first error encountered (see SendBatch).
+func (d *Database) ExecBatch(spanName string, ctx context.Context, b *Batch) error {
+	results := d.SendBatch(spanName, ctx, b)
+	for i := 0; i < b.Len(); i++ {
+		if err := results.Exec(); err != nil {
+			_ = results.Close()
+			return err
+		}
+	}
+	return results.Close()
+}
+
+// record notes the first error encountered, so that it is reported once closed.
+func (r *BatchResults) record(err error) error {
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	err = convertUserError(err)
+	errchk.Check(err, r.spanName)
+	return err
+}
+
+// Exec reads the result of the next statement, ignoring any rows.
+func (r *BatchResults) Exec() error {
+	tag, err := r.results.Exec()
+	r.rows += tag.RowsAffected()
+	return r.record(err)
+}
+
+// Query reads the rows returned by the next statement. The rows must be closed
+// before reading the next result.
+func (r *BatchResults) Query() (pgx.Rows, error) {
+	rows, err := r.results.Query()
+	return rows, r.record(err)
+}
+
+// Scan reads the single row returned by the next statement into the destination(s).
+// As with QueryRow, no rows is not reported as an error.
+func (r *BatchResults) Scan(dest ...interface{}) error {
+	err := r.results.QueryRow().Scan(dest...)
+	switch {
+	case err == nil:
+		r.rows++
+		return nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil
+	}
+	return r.record(err)
+}
+
+// Close reads any remaining results, and ends the batch's span.
+func (r *BatchResults) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	err := r.results.Close()
+	if r.err == nil {
+		err = r.record(err)
+	} else {
+		err = convertUserError(err) // already reported
+	}
+	r.end(r.rows, r.err)
+	return err
+}
+
+// primary returns the transaction queries made using ctx should run in (see txFor), if any,
+// or the primary's pool otherwise.
+func (d *Database) primary(ctx context.Context) querier {
+	if tx, ok := d.txFor(ctx); ok {
+		return tx
+	}
+	return d.pool
+}
@@ -0,0 +1,39 @@
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBatchQueue(t *testing.T) {
+	b := &Batch{}
+	assert.Equal(t, 0, b.Len())
+
+	b.Queue(`INSERT INTO tags (name) VALUES ($1)`, "a")
+	b.Queue(`INSERT INTO tags (name) VALUES ($1)`, "b")
+	assert.Equal(t, 2, b.Len())
+	assert.Len(t, b.queries, 2)
+}
+
+func TestStructSource(t *testing.T) {
+	type tag struct {
+		ID      int       `db:"id,pk,readonly"`
+		Name    string    `db:"name"`
+		Colour  string    `json:"colour"`
+		Created time.Time `db:"created,readonly"`
+		Ignored string    `db:"-"`
+	}
+
+	columns, src := structSource([]tag{{ID: 1, Name: "a", Colour: "red"}, {Name: "b"}})
+	assert.Equal(t, []string{"name", "colour"}, columns)
+
+	rows := make([][]interface{}, 0)
+	for src.Next() {
+		row, err := src.Values()
+		assert.Nil(t, err)
+		rows = append(rows, row)
+	}
+	assert.Nil(t, src.Err())
+	assert.Equal(t, [][]interface{}{{"a", "red"}, {"b", ""}}, rows)
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kaphos/webapp/pkg/utils"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxConnectBackoff     = 30 * time.Second
+	defaultConnectRetries = 5 // waits for up to 31s in total, with the backoff doubling from 1s
+)
+
+// tlsParams maps connection string parameters to the environment variables that set them.
+var tlsParams = [][2]string{
+	{"sslmode", "DB_SSLMODE"},
+	{"sslrootcert", "DB_SSLROOTCERT"},
+	{"sslcert", "DB_SSLCERT"},
+	{"sslkey", "DB_SSLKEY"},
+}
+
+// connString returns the connection string for the primary: DATABASE_URL if set, or one
+// built from DB_USER, DB_PASS, DB_NAME (defaulting to the user), DB_HOST and DB_PORT (or
+// INSTANCE_UNIX_SOCKET) otherwise. DATABASE_URL, DB_USER and DB_PASS can also be read from
+// files (see utils.GetSecret). The TLS settings (DB_SSLMODE, DB_SSLROOTCERT, DB_SSLCERT and
+// DB_SSLKEY) are applied to either, if set.
+func connString(defaultUser, defaultPass string) (string, error) {
+	databaseURL, err := utils.GetSecret("DATABASE_URL", "")
+	if err != nil {
+		return "", err
+	}
+	if databaseURL != "" {
+		connStr, err := withTLSParams(databaseURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid DATABASE_URL: %w", err)
+		}
+		return connStr, nil
+	}
+
+	dbUser, err := utils.GetSecret("DB_USER", defaultUser)
+	if err != nil {
+		return "", err
+	}
+	dbPass, err := utils.GetSecret("DB_PASS", defaultPass)
+	if err != nil {
+		return "", err
+	}
+
+	params := [][2]string{
+		{"dbname", utils.GetEnv("DB_NAME", dbUser)},
+		{"user", dbUser},
+		{"password", dbPass},
+	}
+	if unixSocket := utils.GetEnv("INSTANCE_UNIX_SOCKET", ""); unixSocket != "" {
+		params = append(params, [2]string{"host", unixSocket})
+	} else {
+		params = append(params, [2]string{"host", utils.GetEnv("DB_HOST", "127.0.0.1")}, [2]string{"port", utils.GetEnv("DB_PORT", "5432")})
+	}
+	for _, param := range tlsParams {
+		if val := utils.GetEnv(param[1], ""); val != "" {
+			params = append(params, [2]string{param[0], val})
+		}
+	}
+
+	pairs := make([]string, len(params))
+	for i, param := range params {
+		pairs[i] = param[0] + "=" + quoteDSNValue(param[1])
+	}
+	return strings.Join(pairs, " "), nil
+}
+
+// withTLSParams adds the TLS settings to a URL connection string, overriding any it sets.
+func withTLSParams(connURL string) (string, error) {
+	parsed, err := url.Parse(connURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := parsed.Query()
+	for _, param := range tlsParams {
+		if val := utils.GetEnv(param[1], ""); val != "" {
+			query.Set(param[0], val)
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
+
+// quoteDSNValue quotes a value for a keyword/value connection string, if needed.
+func quoteDSNValue(val string) string {
+	if val != "" && !strings.ContainsAny(val, ` '\`) {
+		return val
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(val) + "'"
+}
+
+// tunePool applies the pool settings: DB_MAX_CONNS (overriding maxConns), DB_MIN_CONNS,
+// DB_MAX_CONN_LIFETIME, DB_MAX_CONN_IDLE_TIME, DB_HEALTH_CHECK_PERIOD and
+// DB_CONNECT_TIMEOUT. Durations are given as e.g. "30m"; pgxpool's defaults are kept for
+// any not set.
+func (d *Database) tunePool(config *pgxpool.Config, maxConns int32) {
+	config.MaxConns = d.parseInt("DB_MAX_CONNS", maxConns)
+	config.MinConns = d.parseInt("DB_MIN_CONNS", config.MinConns)
+	config.MaxConnLifetime = d.parseTimeout("DB_MAX_CONN_LIFETIME", config.MaxConnLifetime)
+	config.MaxConnIdleTime = d.parseTimeout("DB_MAX_CONN_IDLE_TIME", config.MaxConnIdleTime)
+	config.HealthCheckPeriod = d.parseTimeout("DB_HEALTH_CHECK_PERIOD", config.HealthCheckPeriod)
+	config.ConnConfig.ConnectTimeout = d.parseTimeout("DB_CONNECT_TIMEOUT", config.ConnConfig.ConnectTimeout)
+}
+
+// parseInt reads a (positive) number from an environment variable.
+func (d *Database) parseInt(key string, fallback int32) int32 {
+	val := utils.GetEnv(key, "")
+	if val == "" {
+		return fallback
+	}
+
+	num, err := strconv.ParseInt(val, 10, 32)
+	if err != nil || num < 0 {
+		d.logger.Warn("Invalid " + key + " \"" + val + "\"; using " + strconv.Itoa(int(fallback)))
+		return fallback
+	}
+	return int32(num)
+}
+
+// waitForDB pings the database until it is reachable, retrying up to DB_CONNECT_RETRIES
+// times (default 5), with a backoff between attempts (see connectBackoff). If set to 0, the
+// database is not waited for, and connections are only made as needed.
+func (d *Database) waitForDB(connectTimeout time.Duration) error {
+	retries := int(d.parseInt("DB_CONNECT_RETRIES", defaultConnectRetries))
+	if retries == 0 {
+		return nil
+	}
+
+	if connectTimeout <= 0 {
+		connectTimeout = listenConnectTimeout
+	}
+
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+		err := d.pool.Ping(ctx)
+		cancel()
+		if err == nil || attempt >= retries {
+			return err
+		}
+
+		backoff := connectBackoff(attempt)
+		d.logger.Warn(fmt.Sprintf("Database unreachable (%s); retrying in %s (%d/%d)", err, backoff, attempt+1, retries))
+		time.Sleep(backoff)
+	}
+}
+
+// connectBackoff returns how long to wait before reconnecting, doubling from 1s with each
+// attempt, up to 30s.
+func connectBackoff(attempt int) time.Duration {
+	backoff := time.Second << attempt
+	if backoff > maxConnectBackoff || backoff <= 0 {
+		return maxConnectBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// clearDBEnv unsets the variables read by connString, so that the environment running the
+// tests does not affect them.
+func clearDBEnv(t *testing.T) {
+	for _, key := range []string{"DATABASE_URL", "DATABASE_URL_FILE", "DB_USER", "DB_USER_FILE", "DB_PASS",
+		"DB_PASS_FILE", "DB_NAME", "DB_HOST", "DB_PORT", "INSTANCE_UNIX_SOCKET",
+		"DB_SSLMODE", "DB_SSLROOTCERT", "DB_SSLCERT", "DB_SSLKEY"} {
+		t.Setenv(key, "")
+	}
+}
+
+func TestConnString(t *testing.T) {
+	clearDBEnv(t)
+
+	connStr, err := connString("app", "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "dbname=app user=app password=secret host=127.0.0.1 port=5432", connStr)
+
+	t.Setenv("DB_NAME", "appdb")
+	t.Setenv("DB_PASS", "it's secret")
+	t.Setenv("DB_SSLMODE", "verify-full")
+	t.Setenv("DB_SSLROOTCERT", "/certs/ca.pem")
+	connStr, err = connString("app", "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, `dbname=appdb user=app password='it\'s secret' host=127.0.0.1 port=5432 sslmode=verify-full sslrootcert=/certs/ca.pem`, connStr)
+
+	t.Setenv("INSTANCE_UNIX_SOCKET", "/cloudsql/instance")
+	connStr, err = connString("app", "secret")
+	assert.Nil(t, err)
+	assert.Contains(t, connStr, "host=/cloudsql/instance sslmode")
+	assert.NotContains(t, connStr, "port=")
+}
+
+func TestConnStringURL(t *testing.T) {
+	clearDBEnv(t)
+
+	path := filepath.Join(t.TempDir(), "url")
+	assert.Nil(t, os.WriteFile(path, []byte("postgres://app:secret@db:5432/appdb?sslmode=disable\n"), 0600))
+	t.Setenv("DATABASE_URL_FILE", path)
+	t.Setenv("DB_USER", "ignored")
+
+	connStr, err := connString("app", "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "postgres://app:secret@db:5432/appdb?sslmode=disable", connStr)
+
+	t.Setenv("DB_SSLMODE", "require")
+	connStr, err = connString("app", "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, "postgres://app:secret@db:5432/appdb?sslmode=require", connStr)
+
+	t.Setenv("DATABASE_URL_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err = connString("app", "secret")
+	assert.NotNil(t, err)
+}
+
+func TestQuoteDSNValue(t *testing.T) {
+	assert.Equal(t, "plain", quoteDSNValue("plain"))
+	assert.Equal(t, "''", quoteDSNValue(""))
+	assert.Equal(t, `'with space'`, quoteDSNValue("with space"))
+	assert.Equal(t, `'back\\slash'`, quoteDSNValue(`back\slash`))
+}
+
+func TestTunePool(t *testing.T) {
+	d := &Database{logger: zap.NewNop()}
+	config, err := pgxpool.ParseConfig("host=127.0.0.1 user=app")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defaultLifetime := config.MaxConnLifetime
+
+	t.Setenv("DB_MAX_CONNS", "")
+	t.Setenv("DB_MIN_CONNS", "2")
+	t.Setenv("DB_MAX_CONN_LIFETIME", "")
+	t.Setenv("DB_MAX_CONN_IDLE_TIME", "5m")
+	t.Setenv("DB_HEALTH_CHECK_PERIOD", "10s")
+	t.Setenv("DB_CONNECT_TIMEOUT", "3s")
+	d.tunePool(config, 8)
+
+	assert.Equal(t, int32(8), config.MaxConns)
+	assert.Equal(t, int32(2), config.MinConns)
+	assert.Equal(t, defaultLifetime, config.MaxConnLifetime)
+	assert.Equal(t, 5*time.Minute, config.MaxConnIdleTime)
+	assert.Equal(t, 10*time.Second, config.HealthCheckPeriod)
+	assert.Equal(t, 3*time.Second, config.ConnConfig.ConnectTimeout)
+
+	t.Setenv("DB_MAX_CONNS", "20")
+	t.Setenv("DB_MIN_CONNS", "many")
+	d.tunePool(config, 8)
+	assert.Equal(t, int32(20), config.MaxConns)
+	assert.Equal(t, int32(2), config.MinConns)
+}
+
+func TestConnectBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, connectBackoff(0))
+	assert.Equal(t, 4*time.Second, connectBackoff(2))
+	assert.Equal(t, maxConnectBackoff, connectBackoff(10))
+	assert.Equal(t, maxConnectBackoff, connectBackoff(100))
+}
+
+func TestWaitForDB(t *testing.T) {
+	config, err := pgxpool.ParseConfig("host=127.0.0.1 port=1 user=app dbname=app")
+	if !assert.Nil(t, err) {
+		return
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer pool.Close()
+	d := &Database{logger: zap.NewNop(), pool: pool}
+
+	t.Setenv("DB_CONNECT_RETRIES", "0")
+	assert.Nil(t, d.waitForDB(100*time.Millisecond))
+
+	t.Setenv("DB_CONNECT_RETRIES", "1")
+	start := time.Now()
+	assert.NotNil(t, d.waitForDB(100*time.Millisecond))
+	assert.GreaterOrEqual(t, time.Since(start), connectBackoff(0))
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/kaphos/webapp/internal/structmap"
+	"github.com/kaphos/webapp/pkg/errchk"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const opCopy = "copy"
+
+// CopyFrom bulk inserts rows into a table (optionally schema-qualified, e.g. "audit.events")
+// using the COPY protocol, which is much faster than inserting rows one at a time. Rows are
+// read from src as they are sent, so large imports can be streamed (see pgx.CopyFromRows,
+// pgx.CopyFromSlice, or implement pgx.CopyFromSource). Always runs on the primary (or within
+// the transaction carried by ctx), within a single timeout (see WithTimeout to extend it).
+// Returns the number of rows inserted. Any errors encountered internally are automatically
+// handled using the errorhandling package.
+func (d *Database) CopyFrom(spanName string, parentCtx context.Context, table string, columns []string, src pgx.CopyFromSource) (int64, error) {
+	start := time.Now()
+	spanCtx, span := d.tracer.Start(parentCtx, spanName)
+	defer span.End()
+
+	ctx, cancel := d.withTimeout(spanCtx)
+
+	q := d.primary(ctx)
+	markWrite(ctx)
+
+	identifier := pgx.Identifier(strings.Split(table, "."))
+	count, copyErr := q.CopyFrom(ctx, identifier, columns, src)
+	cancel()
+
+	query := "COPY " + identifier.Sanitize() + " (" + strings.Join(columns, ", ") + ") FROM STDIN"
+	d.observe(spanCtx, span, execution{spanName, opCopy, primaryPool, nil, query, nil, start, count, copyErr})
+
+	err := convertUserError(copyErr)
+	errchk.Check(err, spanName)
+	return count, err
+}
+
+// CopyStructs bulk inserts entities into a table using CopyFrom. Columns are mapped from the
+// struct's fields as for scanning (see the structmap package), skipping fields marked
+// readonly (e.g. `db:"id,readonly"`), so that the database fills them in.
+func CopyStructs[T any](d *Database, spanName string, ctx context.Context, table string, entities []T) (int64, error) {
+	columns, src := structSource(entities)
+	return d.CopyFrom(spanName, ctx, table, columns, src)
+}
+
+// structSource returns the writable columns of T, and a source reading their values from entities.
+func structSource[T any](entities []T) ([]string, pgx.CopyFromSource) {
+	fields := make([]structmap.Field, 0)
+	columns := make([]string, 0)
+	for _, f := range structmap.Of[T]() {
+		if !f.HasOption("readonly") {
+			fields = append(fields, f)
+			columns = append(columns, f.Column)
+		}
+	}
+
+	src := pgx.CopyFromSlice(len(entities), func(i int) ([]interface{}, error) {
+		val := reflect.ValueOf(&entities[i]).Elem()
+		row := make([]interface{}, len(fields))
+		for j, f := range fields {
+			row[j] = val.FieldByIndex(f.Index).Interface()
+		}
+		return row, nil
+	})
+
+	return columns, src
+}
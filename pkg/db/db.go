@@ -4,11 +4,14 @@ package db
 
 import (
 	"context"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/kaphos/webapp/internal/telemetry"
+	"github.com/kaphos/webapp/pkg/errchk"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"math/rand"
+	"sync/atomic"
 	"time"
 
 	"github.com/kaphos/webapp/internal/log"
@@ -16,28 +19,81 @@ import (
 
 // Database - used to connect to the database
 type Database struct {
-	pool   *pgxpool.Pool
-	tracer trace.Tracer
-	logger *zap.Logger
+	pool          *pgxpool.Pool
+	tracer        trace.Tracer
+	logger        *zap.Logger
+	timeout       time.Duration // default timeout for each query
+	slowThreshold time.Duration // queries taking longer than this are logged
+	explainRate   float64       // fraction of slow queries that are explained
+	replicas      []*replica    // read replicas, if configured
+	strategy      string        // how replicas are selected (RoundRobin or LeastConns)
+	next          atomic.Uint64 // round-robin counter
+	closing       context.Context
+	close         context.CancelFunc
+
+	connConfig    *pgx.ConnConfig   // for connections outside the pool (see Listen)
+	settings      map[string]string // session settings applied to each connection
+	listener      listener
+	pinned        atomic.Pointer[pgx.Tx] // set using UseTx
+	listening     context.Context        // cancelled once StopListening is called
+	stopListening context.CancelFunc
 }
 
 // NewDB initialises a new Database object, creating a Database pool and setting up logging
-// and telemetry.
+// and telemetry. The connection is configured using DATABASE_URL, or DB_USER, DB_PASS,
+// DB_NAME, DB_HOST and DB_PORT (with the user and password defaulting to defaultUser and
+// defaultPass), along with DB_SSLMODE, DB_SSLROOTCERT, DB_SSLCERT and DB_SSLKEY. Secrets can
+// instead be read from files (e.g. DB_PASS_FILE). The pool is tuned using DB_MAX_CONNS
+// (defaulting to maxConns), DB_MIN_CONNS, DB_MAX_CONN_LIFETIME, DB_MAX_CONN_IDLE_TIME,
+// DB_HEALTH_CHECK_PERIOD and DB_CONNECT_TIMEOUT. Waits for the database to be reachable,
+// retrying DB_CONNECT_RETRIES times (default 5, or 0 to not wait) with a backoff.
+//
+// If DB_REPLICA_URLS is set, read-only queries are spread across those replicas (selected
+// according to DB_REPLICA_STRATEGY, "round-robin" or "least-conns"). Each connection
+// identifies itself using appName, and has the session timeouts set from
+// DB_STATEMENT_TIMEOUT, DB_LOCK_TIMEOUT and DB_IDLE_IN_TX_TIMEOUT (if set).
+// Queries slower than DB_SLOW_QUERY_THRESHOLD are logged, and a sample of them
+// (DB_EXPLAIN_SAMPLE_RATE) explained outside of production.
 func NewDB(appName, defaultUser, defaultPass string, maxConns int32) (*Database, error) {
+	return newDB(appName, defaultUser, defaultPass, maxConns, true)
+}
+
+// NewLazyDB is similar to NewDB, but does not wait for the database to be reachable
+// (regardless of DB_CONNECT_RETRIES); connections are only made as needed.
+func NewLazyDB(appName, defaultUser, defaultPass string, maxConns int32) (*Database, error) {
+	return newDB(appName, defaultUser, defaultPass, maxConns, false)
+}
+
+func newDB(appName, defaultUser, defaultPass string, maxConns int32, wait bool) (*Database, error) {
 	rand.Seed(time.Now().UTC().UnixNano()) // set rand seed just in case. useful for testing.
 
 	d := Database{
 		logger: log.Get("DB"),
 		tracer: telemetry.NewTracer(appName, "database"),
 	}
+	d.closing, d.close = context.WithCancel(context.Background())
+	d.listening, d.stopListening = context.WithCancel(d.closing)
+
+	connStr, err := connString(defaultUser, defaultPass)
+	if err != nil {
+		d.logger.Error("Unable to read database config: " + err.Error())
+		return &Database{}, err
+	}
 
-	config, err := pgxpool.ParseConfig(getDBConnStr(defaultUser, defaultPass))
+	config, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
 		d.logger.Error("Unable to parse database config: " + err.Error())
 		return &Database{}, err
 	}
 
-	config.MaxConns = maxConns
+	settings := sessionSettings(appName)
+	d.tunePool(config, maxConns)
+	d.settings = settings
+	d.connConfig = config.ConnConfig.Copy()
+	config.AfterConnect = applySettings(settings)
+	d.timeout = d.parseTimeout("DB_TIMEOUT", defaultTimeout)
+	d.slowThreshold = d.parseTimeout("DB_SLOW_QUERY_THRESHOLD", defaultSlowQueryThreshold)
+	d.explainRate = d.parseExplainRate()
 
 	d.pool, err = pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
@@ -45,6 +101,21 @@ func NewDB(appName, defaultUser, defaultPass string, maxConns int32) (*Database,
 		return &Database{}, err
 	}
 
+	if wait {
+		if err := d.waitForDB(config.ConnConfig.ConnectTimeout); err != nil {
+			d.logger.Error("Database unreachable: " + err.Error())
+			d.pool.Close()
+			return &Database{}, err
+		}
+	}
+
+	if err := d.connectReplicas(settings, maxConns); err != nil {
+		d.logger.Error("Unable to connect to replicas: " + err.Error())
+		d.Close()
+		return &Database{}, err
+	}
+
+	d.registerPools()
 	d.logger.Info("Connected to database.")
 
 	return &d, nil
@@ -53,3 +124,12 @@ func NewDB(appName, defaultUser, defaultPass string, maxConns int32) (*Database,
 func (d *Database) Healthcheck(ctx context.Context) error {
 	return d.pool.Ping(ctx)
 }
+
+// Acquire returns a dedicated connection from the pool, for operations that must run on a
+// single connection (e.g. session-level advisory locks). The connection must be released
+// once done. Prefer the wrapper functions (Query, Exec, etc.) for everything else.
+func (d *Database) Acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	conn, err := d.pool.Acquire(ctx)
+	errchk.Check(err, "dbAcquire")
+	return conn, err
+}
@@ -0,0 +1,246 @@
+// Package dbtest provides a scriptable fake implementing db.DB, so that repos can be unit
+// tested without a database. Expected statements are matched by their SQL (exactly, ignoring
+// differences in whitespace, or by a regular expression) and arguments, and return canned
+// rows or errors:
+//
+//	fake := dbtest.New()
+//	fake.Expect(`SELECT id, name FROM users WHERE id = $1`).WithArgs(1).
+//		WillReturnRows([]string{"id", "name"}, []interface{}{1, "John"})
+//	...
+//	assert.Nil(t, fake.ExpectationsWereMet())
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kaphos/webapp/pkg/db"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrUnexpected is returned for statements that do not match any remaining expectation.
+var ErrUnexpected = errors.New("dbtest: unexpected statement")
+
+type anyArg struct{}
+
+// Any matches any argument (see Expectation.WithArgs).
+var Any = anyArg{}
+
+// Expectation is a statement the fake expects to receive, and how it should respond.
+// Each expectation is met once, unless Times is used.
+type Expectation struct {
+	sql       string
+	pattern   *regexp.Regexp
+	args      []interface{}
+	checkArgs bool
+	columns   []string
+	rows      [][]interface{}
+	err       error
+	times     int
+	calls     int
+}
+
+// WithArgs requires the statement to be called with exactly these arguments (compared
+// using reflect.DeepEqual). Use Any to match any value for an argument.
+func (e *Expectation) WithArgs(args ...interface{}) *Expectation {
+	e.args = args
+	e.checkArgs = true
+	return e
+}
+
+// WillReturnRows sets the rows returned by the statement, each with a value per column.
+func (e *Expectation) WillReturnRows(columns []string, rows ...[]interface{}) *Expectation {
+	e.columns = columns
+	e.rows = rows
+	return e
+}
+
+// WillReturnError makes the statement fail with err (e.g. a *pgconn.PgError, which is
+// converted in the same way as errors from Postgres).
+func (e *Expectation) WillReturnError(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Times sets how many times the statement is expected to be called.
+func (e *Expectation) Times(times int) *Expectation {
+	e.times = times
+	return e
+}
+
+func (e *Expectation) String() string {
+	if e.pattern != nil {
+		return "/" + e.pattern.String() + "/"
+	}
+	return e.sql
+}
+
+func (e *Expectation) matches(query string, args []interface{}) bool {
+	if e.calls >= e.times {
+		return false
+	}
+
+	if e.pattern != nil {
+		if !e.pattern.MatchString(query) {
+			return false
+		}
+	} else if normalise(query) != e.sql {
+		return false
+	}
+
+	if !e.checkArgs {
+		return true
+	}
+	if len(args) != len(e.args) {
+		return false
+	}
+	for i, arg := range e.args {
+		if arg != Any && !reflect.DeepEqual(arg, args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fake implements db.DB, responding to statements according to its expectations.
+// Safe for concurrent use.
+type Fake struct {
+	mu           sync.Mutex
+	expectations []*Expectation
+	unexpected   []string
+}
+
+var _ db.DB = &Fake{}
+
+// New returns a fake with no expectations.
+func New() *Fake {
+	return &Fake{}
+}
+
+// Expect adds an expected statement, matched exactly (ignoring differences in whitespace).
+func (f *Fake) Expect(sql string) *Expectation {
+	return f.add(&Expectation{sql: normalise(sql), times: 1})
+}
+
+// ExpectRegex adds an expected statement, matched using a regular expression.
+// Panics if pattern is invalid.
+func (f *Fake) ExpectRegex(pattern string) *Expectation {
+	return f.add(&Expectation{pattern: regexp.MustCompile(pattern), times: 1})
+}
+
+func (f *Fake) add(e *Expectation) *Expectation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expectations = append(f.expectations, e)
+	return e
+}
+
+// ExpectationsWereMet returns an error describing any expectations that were not met,
+// and any unexpected statements received.
+func (f *Fake) ExpectationsWereMet() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	problems := make([]string, 0)
+	for _, e := range f.expectations {
+		if e.calls < e.times {
+			problems = append(problems, fmt.Sprintf("expected %s to be called %d time(s), but was called %d time(s)", e, e.times, e.calls))
+		}
+	}
+	for _, query := range f.unexpected {
+		problems = append(problems, "unexpected statement: "+query)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("dbtest: " + strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// match returns the first expectation matching the statement, recording the call, along
+// with the error it should fail with (if any).
+func (f *Fake) match(query string, args []interface{}) (*Expectation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, e := range f.expectations {
+		if e.matches(query, args) {
+			e.calls++
+			return e, e.err
+		}
+	}
+
+	f.unexpected = append(f.unexpected, fmt.Sprintf("%s %v", normalise(query), args))
+	return nil, fmt.Errorf("%w: %s", ErrUnexpected, normalise(query))
+}
+
+// Query implements db.DB.
+func (f *Fake) Query(_ string, ctx context.Context, query string, args ...interface{}) (pgx.Rows, func(), error) {
+	e, err := f.match(query, args)
+	if err != nil {
+		return nil, func() {}, db.ConvertError(err)
+	}
+	return newRows(e.columns, e.rows), func() {}, nil
+}
+
+// QueryRow implements db.DB.
+func (f *Fake) QueryRow(spanName string, ctx context.Context, query string, args ...interface{}) db.QueryRowResult {
+	e, err := f.match(query, args)
+	if err != nil {
+		return db.NewQueryRowResult(spanName, errRow{err})
+	}
+	return db.NewQueryRowResult(spanName, firstRow{newRows(e.columns, e.rows)})
+}
+
+// Exec implements db.DB.
+func (f *Fake) Exec(_ string, ctx context.Context, query string, args ...interface{}) error {
+	_, err := f.match(query, args)
+	return db.ConvertError(err)
+}
+
+// NewTransaction implements db.DB. The transaction's statements are matched against the
+// fake's expectations; committing and rolling back have no effect.
+func (f *Fake) NewTransaction(ctx context.Context, _ string, fn func(tx pgx.Tx) error) error {
+	return fn(&tx{fake: f})
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func normalise(query string) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(query, " "))
+}
+
+// tx is a fake transaction. Only Exec, Query and QueryRow are supported.
+type tx struct {
+	pgx.Tx
+	fake *Fake
+}
+
+func (t *tx) Commit(context.Context) error   { return nil }
+func (t *tx) Rollback(context.Context) error { return nil }
+
+func (t *tx) Exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	_, err := t.fake.match(query, args)
+	return pgconn.CommandTag{}, err
+}
+
+func (t *tx) Query(ctx context.Context, query string, args ...interface{}) (pgx.Rows, error) {
+	e, err := t.fake.match(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(e.columns, e.rows), nil
+}
+
+func (t *tx) QueryRow(ctx context.Context, query string, args ...interface{}) pgx.Row {
+	rows, err := t.Query(ctx, query, args...)
+	if err != nil {
+		return errRow{err}
+	}
+	return firstRow{rows.(*fakeRows)}
+}
@@ -0,0 +1,111 @@
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kaphos/webapp/pkg/db"
+	"github.com/kaphos/webapp/pkg/errchk"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type user struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+func TestQueryRows(t *testing.T) {
+	fake := New()
+	fake.Expect(`SELECT id, name
+		FROM users WHERE name LIKE $1`).WithArgs("J%").
+		WillReturnRows([]string{"id", "name"}, []interface{}{1, "John"}, []interface{}{2, "Jane"})
+
+	users, err := db.QueryAll[user](fake, "getUsers", context.Background(), `SELECT id, name FROM users WHERE name LIKE $1`, "J%")
+	assert.Nil(t, err)
+	assert.Equal(t, []user{{1, "John"}, {2, "Jane"}}, users)
+	assert.Nil(t, fake.ExpectationsWereMet())
+}
+
+func TestQueryRow(t *testing.T) {
+	fake := New()
+	fake.ExpectRegex(`SELECT count\(\*\) FROM users`).WillReturnRows([]string{"count"}, []interface{}{3})
+	fake.ExpectRegex(`SELECT name FROM users`).WithArgs(Any).WillReturnRows([]string{"name"})
+
+	var count int64
+	assert.Nil(t, fake.QueryRow("countUsers", context.Background(), `SELECT count(*) FROM users`).Scan(&count))
+	assert.Equal(t, int64(3), count)
+
+	name := "unchanged"
+	err := fake.QueryRow("getUser", context.Background(), `SELECT name FROM users WHERE id = $1`, 9).Scan(&name)
+	assert.Nil(t, err) // as with Database, no rows is not an error
+	assert.Equal(t, "unchanged", name)
+
+	assert.Nil(t, fake.ExpectationsWereMet())
+}
+
+func TestErrors(t *testing.T) {
+	fake := New()
+	fake.Expect(`INSERT INTO users (name) VALUES ($1)`).WillReturnError(&pgconn.PgError{Code: "23505"})
+	fake.Expect(`DELETE FROM users`).WillReturnError(errors.New("connection lost"))
+
+	ctx := context.Background()
+	assert.Equal(t, errchk.ErrClientSide, fake.Exec("addUser", ctx, `INSERT INTO users (name) VALUES ($1)`, "John"))
+	assert.EqualError(t, fake.Exec("deleteUsers", ctx, `DELETE FROM users`), "connection lost")
+
+	err := fake.Exec("deleteUsers", ctx, `DELETE FROM users`)
+	assert.ErrorIs(t, err, ErrUnexpected)
+	assert.ErrorContains(t, fake.ExpectationsWereMet(), "unexpected statement: DELETE FROM users")
+}
+
+func TestArgs(t *testing.T) {
+	fake := New()
+	fake.Expect(`UPDATE users SET name = $1 WHERE id = $2`).WithArgs("John", 1)
+
+	ctx := context.Background()
+	query := `UPDATE users SET name = $1 WHERE id = $2`
+	assert.ErrorIs(t, fake.Exec("renameUser", ctx, query, "John", 2), ErrUnexpected)
+	assert.ErrorIs(t, fake.Exec("renameUser", ctx, query, "John"), ErrUnexpected)
+	assert.Nil(t, fake.Exec("renameUser", ctx, query, "John", 1))
+}
+
+func TestExpectationsWereMet(t *testing.T) {
+	fake := New()
+	fake.Expect(`DELETE FROM sessions`).Times(2)
+
+	assert.Nil(t, fake.Exec("clearSessions", context.Background(), `DELETE FROM sessions`))
+	assert.ErrorContains(t, fake.ExpectationsWereMet(), "to be called 2 time(s), but was called 1 time(s)")
+
+	assert.Nil(t, fake.Exec("clearSessions", context.Background(), `DELETE FROM sessions`))
+	assert.Nil(t, fake.ExpectationsWereMet())
+}
+
+func TestTransaction(t *testing.T) {
+	fake := New()
+	fake.Expect(`UPDATE items SET edited = NOW()`)
+	fake.Expect(`SELECT id FROM items`).WillReturnRows([]string{"id"}, []interface{}{"a"})
+
+	err := fake.NewTransaction(context.Background(), "touchItems", func(tx pgx.Tx) error {
+		if _, err := tx.Exec(context.Background(), `UPDATE items SET edited = NOW()`); err != nil {
+			return err
+		}
+
+		var id string
+		if err := tx.QueryRow(context.Background(), `SELECT id FROM items`).Scan(&id); err != nil {
+			return err
+		}
+		assert.Equal(t, "a", id)
+		return tx.Commit(context.Background())
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, fake.ExpectationsWereMet())
+}
+
+func TestScanMismatch(t *testing.T) {
+	fake := New()
+	fake.Expect(`SELECT id FROM users`).WillReturnRows([]string{"id"}, []interface{}{"not a number"})
+
+	_, err := db.QueryAll[int64](fake, "getIDs", context.Background(), `SELECT id FROM users`)
+	assert.ErrorContains(t, err, "cannot assign string to int64")
+}
@@ -0,0 +1,114 @@
+package dbtest
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"reflect"
+)
+
+// fakeRows implements pgx.Rows over canned values.
+type fakeRows struct {
+	columns []string
+	rows    [][]interface{}
+	current int
+	err     error
+	closed  bool
+}
+
+func newRows(columns []string, rows [][]interface{}) *fakeRows {
+	return &fakeRows{columns: columns, rows: rows, current: -1}
+}
+
+func (r *fakeRows) Close()     { r.closed = true }
+func (r *fakeRows) Err() error { return r.err }
+
+func (r *fakeRows) CommandTag() pgconn.CommandTag {
+	return pgconn.NewCommandTag(fmt.Sprintf("SELECT %d", len(r.rows)))
+}
+
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription {
+	fields := make([]pgconn.FieldDescription, len(r.columns))
+	for i, column := range r.columns {
+		fields[i] = pgconn.FieldDescription{Name: column}
+	}
+	return fields
+}
+
+func (r *fakeRows) Next() bool {
+	if r.closed || r.err != nil || r.current+1 >= len(r.rows) {
+		r.closed = true
+		return false
+	}
+	r.current++
+	return true
+}
+
+// Scan assigns the current row's values to dest, converting them where possible
+// (e.g. an int to an int64).
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	row := r.rows[r.current]
+	if len(dest) != len(row) {
+		r.err = fmt.Errorf("dbtest: scanning %d values into %d destinations", len(row), len(dest))
+		return r.err
+	}
+
+	for i, val := range row {
+		if err := assign(dest[i], val); err != nil {
+			r.err = fmt.Errorf("dbtest: column %d: %w", i, err)
+			return r.err
+		}
+	}
+	return nil
+}
+
+func (r *fakeRows) Values() ([]interface{}, error) { return r.rows[r.current], nil }
+func (r *fakeRows) RawValues() [][]byte            { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                { return nil }
+
+// assign sets the value pointed to by dest to val.
+func assign(dest, val interface{}) error {
+	if scanner, ok := dest.(sql.Scanner); ok {
+		return scanner.Scan(val)
+	}
+
+	target := reflect.ValueOf(dest)
+	if target.Kind() != reflect.Pointer || target.IsNil() {
+		return fmt.Errorf("destination %T is not a pointer", dest)
+	}
+	target = target.Elem()
+
+	if val == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	source := reflect.ValueOf(val)
+	switch {
+	case source.Type().AssignableTo(target.Type()):
+		target.Set(source)
+	case source.Type().ConvertibleTo(target.Type()) && (target.Kind() != reflect.String || source.Kind() == reflect.String):
+		// Numbers are not converted to strings, as Go would treat them as runes
+		target.Set(source.Convert(target.Type()))
+	default:
+		return fmt.Errorf("cannot assign %T to %s", val, target.Type())
+	}
+	return nil
+}
+
+// firstRow implements pgx.Row, scanning the first of the rows.
+type firstRow struct{ rows *fakeRows }
+
+func (r firstRow) Scan(dest ...interface{}) error {
+	defer r.rows.Close()
+	if !r.rows.Next() {
+		return pgx.ErrNoRows
+	}
+	return r.rows.Scan(dest...)
+}
+
+// errRow implements pgx.Row, failing with an error.
+type errRow struct{ err error }
+
+func (r errRow) Scan(...interface{}) error { return r.err }
@@ -0,0 +1,465 @@
+// Package fixtures loads declarative data into the database, for tests and local development.
+// Fixture files (YAML or JSON, typically embedded) map table names to labelled rows:
+//
+//	users:
+//	  alice:
+//	    name: Alice
+//	    email: alice@example.com
+//	items:
+//	  laptop:
+//	    id: $uuid
+//	    name: Laptop
+//	    owner: $users.alice.id
+//	    created: $now-24h
+//
+// String values starting with "$" are generated as the rows are inserted:
+//
+//	$uuid                   a random UUID
+//	$now, $now+1h, $now-2h  the current time, optionally offset by a duration
+//	$table.label.column     a column of another row, including ones set by the database
+//	                        (e.g. serial IDs)
+//
+// A leading "$$" stands for a literal "$". Tables are inserted in an order respecting both
+// the references between rows and the database's foreign keys.
+package fixtures
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gofrs/uuid/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/kaphos/webapp/pkg/db"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrCycle is returned if the tables cannot be ordered, as they depend on each other.
+var ErrCycle = errors.New("fixtures: circular dependency between tables")
+
+// Row is an inserted row, keyed by column, with values as returned by the database.
+type Row map[string]interface{}
+
+// Rows are the inserted rows, keyed by table and then by label.
+type Rows map[string]map[string]Row
+
+type row struct {
+	label   string
+	columns []string
+	values  []interface{}
+}
+
+type table struct {
+	name string
+	rows []*row
+}
+
+// Fixtures are the rows loaded from a set of fixture files. Should be created using Load.
+type Fixtures struct {
+	tables []*table // in the order they were first defined
+	byName map[string]*table
+}
+
+// Load reads every .yml, .yaml and .json file in fsys (including subdirectories) as fixtures.
+// A table can be spread across several files, but each of its labels must be unique. Returns
+// an error if a file is not valid, or refers to a row that is not defined.
+func Load(fsys fs.FS) (*Fixtures, error) {
+	f := &Fixtures{byName: make(map[string]*table)}
+
+	err := fs.WalkDir(fsys, ".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		switch path.Ext(p) {
+		case ".yml", ".yaml", ".json":
+		default:
+			return nil
+		}
+
+		contents, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		if err := f.parse(contents); err != nil {
+			return fmt.Errorf("fixtures: %s: %w", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return f, f.checkReferences()
+}
+
+// MustLoad is like Load, but panics if the fixtures cannot be loaded.
+func MustLoad(fsys fs.FS) *Fixtures {
+	f, err := Load(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// Tables returns the names of the tables with fixtures, in the order they were first defined.
+func (f *Fixtures) Tables() []string {
+	names := make([]string, len(f.tables))
+	for i, t := range f.tables {
+		names[i] = t.name
+	}
+	return names
+}
+
+// parse adds the tables in a file, which is parsed as YAML (of which JSON is a subset), so
+// that the order of tables, rows and columns is kept.
+func (f *Fixtures) parse(contents []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil // empty file
+	}
+
+	tables := doc.Content[0]
+	if tables.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping of table names to rows", tables.Line)
+	}
+
+	for i := 0; i < len(tables.Content); i += 2 {
+		name, rows := tables.Content[i].Value, tables.Content[i+1]
+		if rows.Kind != yaml.MappingNode {
+			return fmt.Errorf("line %d: expected a mapping of labels to rows for table %s", rows.Line, name)
+		}
+
+		t, ok := f.byName[name]
+		if !ok {
+			t = &table{name: name}
+			f.byName[name] = t
+			f.tables = append(f.tables, t)
+		}
+
+		for j := 0; j < len(rows.Content); j += 2 {
+			r, err := parseRow(rows.Content[j].Value, rows.Content[j+1])
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", name, r.label, err)
+			}
+			for _, existing := range t.rows {
+				if existing.label == r.label {
+					return fmt.Errorf("%s.%s: label defined more than once", name, r.label)
+				}
+			}
+			t.rows = append(t.rows, r)
+		}
+	}
+
+	return nil
+}
+
+func parseRow(label string, node *yaml.Node) (*row, error) {
+	r := &row{label: label}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return r, nil // inserted with default values
+	}
+	if node.Kind != yaml.MappingNode {
+		return r, fmt.Errorf("line %d: expected a mapping of columns to values", node.Line)
+	}
+
+	for i := 0; i < len(node.Content); i += 2 {
+		var val interface{}
+		if err := node.Content[i+1].Decode(&val); err != nil {
+			return r, err
+		}
+		if str, ok := val.(string); ok {
+			parsed, err := parseValue(str)
+			if err != nil {
+				return r, fmt.Errorf("line %d: %w", node.Content[i+1].Line, err)
+			}
+			val = parsed
+		}
+
+		r.columns = append(r.columns, node.Content[i].Value)
+		r.values = append(r.values, val)
+	}
+
+	return r, nil
+}
+
+// checkReferences ensures every reference is to a row that is defined.
+func (f *Fixtures) checkReferences() error {
+	for _, t := range f.tables {
+		for _, r := range t.rows {
+			for _, val := range r.values {
+				ref, ok := val.(reference)
+				if !ok {
+					continue
+				}
+				if _, ok := f.row(ref.table, ref.label); !ok {
+					return fmt.Errorf("fixtures: %s.%s refers to %s.%s, which is not defined", t.name, r.label, ref.table, ref.label)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Fixtures) row(tableName, label string) (*row, bool) {
+	t, ok := f.byName[tableName]
+	if !ok {
+		return nil, false
+	}
+	for _, r := range t.rows {
+		if r.label == label {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// Insert inserts the fixtures within a single transaction (or a savepoint, if ctx already
+// carries one, e.g. when the database is isolated using the webapptest package), returning
+// the inserted rows.
+func (f *Fixtures) Insert(ctx context.Context, database *db.Database) (Rows, error) {
+	return f.load(ctx, database, false)
+}
+
+// Reload truncates the fixtures' tables (along with any tables referencing them, and
+// restarting their sequences) before inserting the fixtures, all within a single transaction,
+// so that the database is left in the same state each time.
+func (f *Fixtures) Reload(ctx context.Context, database *db.Database) (Rows, error) {
+	return f.load(ctx, database, true)
+}
+
+func (f *Fixtures) load(ctx context.Context, database *db.Database, truncate bool) (Rows, error) {
+	var inserted Rows
+	err := database.Transaction(ctx, "fixtures", func(ctx context.Context) error {
+		if truncate && len(f.tables) > 0 {
+			names := make([]string, len(f.tables))
+			for i, t := range f.tables {
+				names[i] = identifier(t.name)
+			}
+			err := database.Exec("truncateFixtures", ctx, "TRUNCATE "+strings.Join(names, ", ")+" RESTART IDENTITY CASCADE")
+			if err != nil {
+				return err
+			}
+		}
+
+		foreignKeys, err := loadForeignKeys(ctx, database)
+		if err != nil {
+			return err
+		}
+
+		tables, err := f.order(foreignKeys)
+		if err != nil {
+			return err
+		}
+
+		inserted = make(Rows)
+		now := time.Now()
+		for _, t := range tables {
+			inserted[t.name] = make(map[string]Row)
+			for _, r := range t.rows {
+				if err := insertRow(ctx, database, t.name, r, inserted, now); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+
+	return inserted, err
+}
+
+// loadForeignKeys returns the tables each table references through foreign keys.
+func loadForeignKeys(ctx context.Context, database *db.Database) (map[string][]string, error) {
+	rows, end, err := database.Query("loadForeignKeys", ctx,
+		`SELECT conrelid::regclass::text, confrelid::regclass::text FROM pg_constraint WHERE contype = 'f'`)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+
+	foreignKeys := make(map[string][]string)
+	for rows.Next() {
+		var from, to string
+		if err := rows.Scan(&from, &to); err != nil {
+			return nil, err
+		}
+		foreignKeys[from] = append(foreignKeys[from], to)
+	}
+	return foreignKeys, rows.Err()
+}
+
+// order sorts the tables so that each comes after those it references (through the given
+// foreign keys, or references between rows), otherwise keeping the order they were defined in.
+func (f *Fixtures) order(foreignKeys map[string][]string) ([]*table, error) {
+	dependsOn := make(map[string]map[string]bool)
+	for _, t := range f.tables {
+		deps := make(map[string]bool)
+		for _, to := range foreignKeys[t.name] {
+			deps[to] = true
+		}
+		for _, r := range t.rows {
+			for _, val := range r.values {
+				if ref, ok := val.(reference); ok {
+					deps[ref.table] = true
+				}
+			}
+		}
+
+		dependsOn[t.name] = make(map[string]bool)
+		for dep := range deps {
+			if _, ok := f.byName[dep]; ok && dep != t.name {
+				dependsOn[t.name][dep] = true // rows within a table are inserted in order
+			}
+		}
+	}
+
+	ordered := make([]*table, 0, len(f.tables))
+	done := make(map[string]bool)
+	for len(ordered) < len(f.tables) {
+		progressed := false
+		for _, t := range f.tables {
+			if done[t.name] || !allDone(dependsOn[t.name], done) {
+				continue
+			}
+			ordered = append(ordered, t)
+			done[t.name] = true
+			progressed = true
+		}
+
+		if !progressed {
+			remaining := make([]string, 0)
+			for _, t := range f.tables {
+				if !done[t.name] {
+					remaining = append(remaining, t.name)
+				}
+			}
+			return nil, fmt.Errorf("%w: %s", ErrCycle, strings.Join(remaining, ", "))
+		}
+	}
+
+	return ordered, nil
+}
+
+func allDone(deps map[string]bool, done map[string]bool) bool {
+	for dep := range deps {
+		if !done[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// insertRow inserts a single row, recording the row returned by the database.
+func insertRow(ctx context.Context, database *db.Database, tableName string, r *row, inserted Rows, now time.Time) error {
+	columns := make([]string, len(r.columns))
+	params := make([]string, len(r.columns))
+	args := make([]interface{}, len(r.values))
+	for i, column := range r.columns {
+		val, err := resolve(r.values[i], inserted, now)
+		if err != nil {
+			return fmt.Errorf("fixtures: %s.%s: %w", tableName, r.label, err)
+		}
+		columns[i] = identifier(column)
+		params[i] = "$" + strconv.Itoa(i+1)
+		args[i] = val
+	}
+
+	query := "INSERT INTO " + identifier(tableName) + " DEFAULT VALUES RETURNING *"
+	if len(columns) > 0 {
+		query = "INSERT INTO " + identifier(tableName) + " (" + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(params, ", ") + ") RETURNING *"
+	}
+
+	rows, end, err := database.Query("insertFixture", ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("fixtures: %s.%s: %w", tableName, r.label, err)
+	}
+	defer end()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("fixtures: %s.%s: %w", tableName, r.label, err)
+		}
+		return fmt.Errorf("fixtures: %s.%s: no row returned", tableName, r.label)
+	}
+
+	values, err := rows.Values()
+	if err != nil {
+		return fmt.Errorf("fixtures: %s.%s: %w", tableName, r.label, err)
+	}
+	result := make(Row, len(values))
+	for i, field := range rows.FieldDescriptions() {
+		result[field.Name] = values[i]
+	}
+	inserted[tableName][r.label] = result
+	return nil
+}
+
+// identifier quotes a (possibly schema-qualified) table or column name.
+func identifier(name string) string {
+	return pgx.Identifier(strings.Split(name, ".")).Sanitize()
+}
+
+type reference struct{ table, label, column string }
+
+type generatedUUID struct{}
+
+type relativeTime struct{ offset time.Duration }
+
+// parseValue parses a string value from a fixture file, returning what it should be
+// generated as if it starts with "$".
+func parseValue(val string) (interface{}, error) {
+	if !strings.HasPrefix(val, "$") {
+		return val, nil
+	}
+	if strings.HasPrefix(val, "$$") {
+		return val[1:], nil
+	}
+
+	switch {
+	case val == "$uuid":
+		return generatedUUID{}, nil
+	case val == "$now":
+		return relativeTime{}, nil
+	case strings.HasPrefix(val, "$now+") || strings.HasPrefix(val, "$now-"):
+		offset, err := time.ParseDuration(val[len("$now"):])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q: %w", val, err)
+		}
+		return relativeTime{offset}, nil
+	}
+
+	parts := strings.Split(val[1:], ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("invalid value %q: expected $uuid, $now or $table.label.column (use $$ for a literal $)", val)
+	}
+	return reference{parts[0], parts[1], parts[2]}, nil
+}
+
+// resolve returns the value to insert for a (possibly generated) value.
+func resolve(val interface{}, inserted Rows, now time.Time) (interface{}, error) {
+	switch v := val.(type) {
+	case generatedUUID:
+		id, err := uuid.NewV4()
+		return id, err
+	case relativeTime:
+		return now.Add(v.offset), nil
+	case reference:
+		r, ok := inserted[v.table][v.label]
+		if !ok {
+			return nil, fmt.Errorf("refers to %s.%s, which has not been inserted yet", v.table, v.label)
+		}
+		column, ok := r[v.column]
+		if !ok {
+			return nil, fmt.Errorf("refers to %s.%s.%s, which is not a column", v.table, v.label, v.column)
+		}
+		return column, nil
+	default:
+		return val, nil
+	}
+}
@@ -0,0 +1,111 @@
+package fixtures
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	f, err := Load(fstest.MapFS{
+		"fixtures/items.yml": {Data: []byte(`
+items:
+  laptop:
+    id: $uuid
+    name: Laptop
+    owner: $users.alice.id
+    created: $now-24h
+    note: $$5
+`)},
+		"fixtures/users.json": {Data: []byte(`{"users": {"alice": {"name": "Alice", "admin": true}, "bob": null}}`)},
+		"fixtures/README.md":  {Data: []byte("not a fixture")},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, []string{"items", "users"}, f.Tables())
+	laptop, ok := f.row("items", "laptop")
+	if assert.True(t, ok) {
+		assert.Equal(t, []string{"id", "name", "owner", "created", "note"}, laptop.columns)
+		assert.Equal(t, []interface{}{generatedUUID{}, "Laptop", reference{"users", "alice", "id"}, relativeTime{-24 * time.Hour}, "$5"}, laptop.values)
+	}
+
+	bob, ok := f.row("users", "bob")
+	if assert.True(t, ok) {
+		assert.Empty(t, bob.columns)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for name, contents := range map[string]string{
+		"not a mapping":     `- users`,
+		"rows not mapping":  `users: [alice]`,
+		"duplicate label":   `users: {alice: {name: A}}`,
+		"unknown generator": `users: {alice: {id: $serial}}`,
+		"bad time":          `users: {alice: {created: $now+1y}}`,
+		"unknown reference": `items: {laptop: {owner: $users.alice.id}}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			fsys := fstest.MapFS{"a.yml": {Data: []byte(contents)}}
+			if name == "duplicate label" {
+				fsys["b.yml"] = &fstest.MapFile{Data: []byte(`users: {alice: {name: B}}`)}
+			}
+			_, err := Load(fsys)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestOrder(t *testing.T) {
+	f := MustLoad(fstest.MapFS{"a.yml": {Data: []byte(`
+comments:
+  first: {item: $items.laptop.id, text: Hello}
+items:
+  laptop: {name: Laptop}
+  case: {name: Case, parent: $items.laptop.id}
+users:
+  alice: {name: Alice}
+`)}})
+
+	tables, err := f.order(map[string][]string{"items": {"users"}, "users": {"organisations"}})
+	if assert.Nil(t, err) {
+		names := make([]string, len(tables))
+		for i, table := range tables {
+			names[i] = table.name
+		}
+		assert.Equal(t, []string{"users", "items", "comments"}, names)
+	}
+
+	_, err = f.order(map[string][]string{"items": {"users"}, "users": {"comments"}})
+	assert.True(t, errors.Is(err, ErrCycle))
+}
+
+func TestResolve(t *testing.T) {
+	now := time.Now()
+	inserted := Rows{"users": {"alice": {"id": int32(4)}}}
+
+	val, err := resolve(reference{"users", "alice", "id"}, inserted, now)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), val)
+
+	val, err = resolve(relativeTime{time.Hour}, inserted, now)
+	assert.Nil(t, err)
+	assert.Equal(t, now.Add(time.Hour), val)
+
+	first, _ := resolve(generatedUUID{}, inserted, now)
+	second, _ := resolve(generatedUUID{}, inserted, now)
+	assert.NotEqual(t, first, second)
+
+	_, err = resolve(reference{"users", "bob", "id"}, inserted, now)
+	assert.NotNil(t, err)
+	_, err = resolve(reference{"users", "alice", "email"}, inserted, now)
+	assert.NotNil(t, err)
+}
+
+func TestIdentifier(t *testing.T) {
+	assert.Equal(t, `"users"`, identifier("users"))
+	assert.Equal(t, `"auth"."users"`, identifier("auth.users"))
+}
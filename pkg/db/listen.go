@@ -0,0 +1,285 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kaphos/webapp/internal/telemetry"
+	"github.com/kaphos/webapp/pkg/errchk"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	listenCheckInterval  = 30 * time.Second // how often an idle listening connection is checked
+	listenConnectTimeout = 10 * time.Second
+)
+
+// ErrListenerClosed is returned by Listen once the Database has stopped listening
+// (see StopListening).
+var ErrListenerClosed = errors.New("database is no longer listening for notifications")
+
+type subscription struct {
+	handler func(payload string)
+}
+
+// listener receives notifications on a dedicated connection (outside the pool),
+// and dispatches them to the subscribers of each channel.
+type listener struct {
+	mu        sync.Mutex
+	subs      map[string]map[*subscription]struct{}
+	dirty     bool               // set when the channels subscribed to have changed
+	interrupt context.CancelFunc // stops waiting for notifications, so that changes are applied
+	synced    []chan struct{}    // closed once the channels subscribed to are being listened to
+	running   bool
+}
+
+// changed flags that the channels subscribed to have changed. Must be called with mu held.
+func (l *listener) changed() {
+	l.dirty = true
+	if l.interrupt != nil {
+		l.interrupt()
+	}
+}
+
+// Listen subscribes to notifications sent on a channel (using NOTIFY or Notify), until ctx
+// is done. Returns once the channel is being listened to. Notifications are received on a
+// dedicated connection, which is re-established (and the channels listened to again)
+// automatically if lost; notifications sent while it is down are missed. Handlers are called
+// in the order that notifications are received, and so should return quickly.
+func (d *Database) Listen(ctx context.Context, channel string, handler func(payload string)) error {
+	if d.listening.Err() != nil {
+		return ErrListenerClosed
+	}
+
+	sub := &subscription{handler}
+	synced := make(chan struct{})
+
+	l := &d.listener
+	l.mu.Lock()
+	if l.subs == nil {
+		l.subs = make(map[string]map[*subscription]struct{})
+	}
+	if l.subs[channel] == nil {
+		l.subs[channel] = make(map[*subscription]struct{})
+	}
+	l.subs[channel][sub] = struct{}{}
+	l.synced = append(l.synced, synced)
+	l.changed()
+	if !l.running {
+		l.running = true
+		go d.listen()
+	}
+	l.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-d.listening.Done():
+			return
+		}
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.subs[channel], sub)
+		if len(l.subs[channel]) == 0 {
+			delete(l.subs, channel)
+		}
+		l.changed()
+	}()
+
+	select {
+	case <-synced:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-d.listening.Done():
+		return ErrListenerClosed
+	}
+}
+
+// ListenJSON subscribes to notifications on a channel, decoding each payload as JSON.
+// Payloads that cannot be decoded are reported, and otherwise ignored. See Database.Listen.
+func ListenJSON[T any](d *Database, ctx context.Context, channel string, handler func(T)) error {
+	return d.Listen(ctx, channel, func(payload string) {
+		var val T
+		if errchk.HaveError(json.Unmarshal([]byte(payload), &val), "listenJSON:"+channel) {
+			return
+		}
+		handler(val)
+	})
+}
+
+// Notify sends a notification on a channel. If ctx carries a transaction, the notification
+// is only delivered once it commits.
+func (d *Database) Notify(ctx context.Context, channel, payload string) error {
+	err := d.Exec("notify:"+channel, ctx, `SELECT pg_notify($1, $2)`, channel, payload)
+	if err == nil {
+		telemetry.DBNotifications.WithLabelValues(channel, "sent").Inc()
+	}
+	return err
+}
+
+// NotifyJSON sends a notification on a channel, with val encoded as JSON as its payload.
+func (d *Database) NotifyJSON(ctx context.Context, channel string, val interface{}) error {
+	payload, err := json.Marshal(val)
+	if errchk.HaveError(err, "notifyJSON:"+channel) {
+		return err
+	}
+	return d.Notify(ctx, channel, string(payload))
+}
+
+// StopListening closes the listening connection, ending all subscriptions.
+// Called by the Server on shutdown.
+func (d *Database) StopListening() { d.stopListening() }
+
+// listen keeps a listening connection open until StopListening is called,
+// reconnecting with a backoff whenever it is lost.
+func (d *Database) listen() {
+	for attempt := 0; ; attempt++ {
+		conn, err := d.connectListener()
+		if err == nil {
+			attempt = 0
+			err = d.receive(conn)
+			_ = conn.Close(context.Background())
+		}
+
+		if d.listening.Err() != nil {
+			return
+		}
+		d.logger.Warn("Listening connection lost; reconnecting: " + err.Error())
+
+		select {
+		case <-d.listening.Done():
+			return
+		case <-time.After(connectBackoff(attempt)):
+		}
+	}
+}
+
+// connectListener opens a new connection (outside the pool) with the same configuration
+// and session settings as the pool's.
+func (d *Database) connectListener() (*pgx.Conn, error) {
+	ctx, cancel := context.WithTimeout(d.listening, listenConnectTimeout)
+	defer cancel()
+
+	conn, err := pgx.ConnectConfig(ctx, d.connConfig.Copy())
+	if err != nil {
+		return nil, err
+	}
+	if err := applySettings(d.settings)(ctx, conn); err != nil {
+		_ = conn.Close(context.Background())
+		return nil, err
+	}
+	return conn, nil
+}
+
+// receive listens to the channels subscribed to, and dispatches notifications received,
+// until the connection fails. The connection is pinged whenever it has been idle for a
+// while, so that a silently dropped connection is noticed.
+func (d *Database) receive(conn *pgx.Conn) error {
+	l := &d.listener
+	listening := make(map[string]bool)
+
+	for {
+		l.mu.Lock()
+		l.dirty = false
+		channels := make([]string, 0, len(l.subs))
+		for channel := range l.subs {
+			channels = append(channels, channel)
+		}
+		synced := l.synced
+		l.synced = nil
+		l.mu.Unlock()
+
+		if err := syncChannels(d.listening, conn, listening, channels); err != nil {
+			l.mu.Lock()
+			l.synced = append(l.synced, synced...)
+			l.mu.Unlock()
+			return err
+		}
+		for _, ch := range synced {
+			close(ch)
+		}
+
+		l.mu.Lock()
+		if l.dirty {
+			l.mu.Unlock()
+			continue
+		}
+		ctx, cancel := context.WithTimeout(d.listening, listenCheckInterval)
+		l.interrupt = cancel
+		l.mu.Unlock()
+
+		n, err := conn.WaitForNotification(ctx)
+		idle := errors.Is(ctx.Err(), context.DeadlineExceeded)
+
+		l.mu.Lock()
+		l.interrupt = nil
+		l.mu.Unlock()
+		cancel()
+
+		switch {
+		case err == nil:
+			d.dispatch(n)
+		case d.listening.Err() != nil || conn.IsClosed():
+			return err
+		case idle:
+			if err := conn.Ping(d.listening); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// syncChannels issues LISTEN and UNLISTEN so that exactly the given channels are listened to.
+func syncChannels(ctx context.Context, conn *pgx.Conn, listening map[string]bool, channels []string) error {
+	wanted := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		wanted[channel] = true
+		if !listening[channel] {
+			if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+				return err
+			}
+			listening[channel] = true
+		}
+	}
+
+	stale := make([]string, 0)
+	for channel := range listening {
+		if !wanted[channel] {
+			stale = append(stale, channel)
+		}
+	}
+	sort.Strings(stale)
+	for _, channel := range stale {
+		if _, err := conn.Exec(ctx, "UNLISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return err
+		}
+		delete(listening, channel)
+	}
+
+	return nil
+}
+
+// dispatch passes a notification to each of the channel's subscribers, within a span.
+func (d *Database) dispatch(n *pgconn.Notification) {
+	telemetry.DBNotifications.WithLabelValues(n.Channel, "received").Inc()
+
+	_, span := d.tracer.Start(d.listening, "listen:"+n.Channel)
+	defer span.End()
+
+	d.listener.mu.Lock()
+	handlers := make([]func(string), 0, len(d.listener.subs[n.Channel]))
+	for sub := range d.listener.subs[n.Channel] {
+		handlers = append(handlers, sub.handler)
+	}
+	d.listener.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(n.Payload)
+	}
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// testListener returns a Database that is unable to connect, so that its listener keeps
+// retrying in the background.
+func testListener(t *testing.T) *Database {
+	config, err := pgx.ParseConfig("host=127.0.0.1 port=1 user=test connect_timeout=1")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	d := &Database{logger: zap.NewNop(), tracer: trace.NewNoopTracerProvider().Tracer(""), connConfig: config}
+	d.closing, d.close = context.WithCancel(context.Background())
+	d.listening, d.stopListening = context.WithCancel(d.closing)
+	t.Cleanup(d.close)
+	return d
+}
+
+func TestListenUnsubscribes(t *testing.T) {
+	d := testListener(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := d.Listen(ctx, "items", func(string) {})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.Eventually(t, func() bool {
+		d.listener.mu.Lock()
+		defer d.listener.mu.Unlock()
+		return len(d.listener.subs) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestStopListening(t *testing.T) {
+	d := testListener(t)
+
+	done := make(chan error)
+	go func() { done <- d.Listen(context.Background(), "items", func(string) {}) }()
+	d.StopListening()
+
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrListenerClosed)
+	case <-time.After(time.Second):
+		t.Fatal("Listen did not return once stopped")
+	}
+
+	assert.ErrorIs(t, d.Listen(context.Background(), "items", func(string) {}), ErrListenerClosed)
+}
+
+func TestDispatch(t *testing.T) {
+	d := testListener(t)
+	d.listener.subs = map[string]map[*subscription]struct{}{}
+
+	received := make([]string, 0)
+	for _, name := range []string{"a", "b"} {
+		name := name
+		sub := &subscription{func(payload string) { received = append(received, name+":"+payload) }}
+		if d.listener.subs["items"] == nil {
+			d.listener.subs["items"] = make(map[*subscription]struct{})
+		}
+		d.listener.subs["items"][sub] = struct{}{}
+	}
+
+	d.dispatch(&pgconn.Notification{Channel: "items", Payload: "1"})
+	d.dispatch(&pgconn.Notification{Channel: "tags", Payload: "2"})
+	assert.ElementsMatch(t, []string{"a:1", "b:1"}, received)
+}
+
+func TestListenJSON(t *testing.T) {
+	d := testListener(t)
+
+	type event struct {
+		ID int `json:"id"`
+	}
+	received := make([]event, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = ListenJSON(d, ctx, "events", func(e event) { received = append(received, e) }) }()
+
+	var handler func(string)
+	assert.Eventually(t, func() bool {
+		d.listener.mu.Lock()
+		defer d.listener.mu.Unlock()
+		for sub := range d.listener.subs["events"] {
+			handler = sub.handler
+		}
+		return handler != nil
+	}, time.Second, 10*time.Millisecond)
+
+	handler(`{"id": 3}`)
+	handler(`not json`)
+	assert.Equal(t, []event{{ID: 3}}, received)
+}
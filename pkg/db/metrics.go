@@ -0,0 +1,48 @@
+package db
+
+import (
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/kaphos/webapp/internal/telemetry"
+	"github.com/kaphos/webapp/pkg/errchk"
+	"time"
+)
+
+// Operation types and outcomes, as used to label SQL metrics.
+const (
+	opQuery    = "query"
+	opQueryRow = "query_row"
+	opExec     = "exec"
+
+	outcomeOK          = "ok"
+	outcomeNoRows      = "no_rows"
+	outcomeClientError = "client_error"
+	outcomeError       = "error"
+)
+
+// outcome classifies the (unconverted) error returned by a query.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return outcomeOK
+	case errors.Is(err, pgx.ErrNoRows), errors.Is(err, errchk.ErrNoRows):
+		return outcomeNoRows
+	case errors.Is(convertUserError(err), errchk.ErrClientSide):
+		return outcomeClientError
+	default:
+		return outcomeError
+	}
+}
+
+// logSQL records the duration and outcome of a query.
+func logSQL(spanName, operation, pool string, start time.Time, err error) {
+	telemetry.PromLogSQL(spanName, operation, outcome(err), pool, time.Since(start).Seconds())
+}
+
+// registerPools exports the statistics of the primary and replica pools.
+func (d *Database) registerPools() {
+	telemetry.RegisterPool(primaryPool, d.pool.Stat)
+	for _, r := range d.replicas {
+		telemetry.RegisterPool(r.name, r.pool.Stat)
+	}
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kaphos/webapp/internal/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutcome(t *testing.T) {
+	assert.Equal(t, outcomeOK, outcome(nil))
+	assert.Equal(t, outcomeNoRows, outcome(pgx.ErrNoRows))
+	assert.Equal(t, outcomeNoRows, outcome(fmt.Errorf("scanning: %w", pgx.ErrNoRows)))
+	assert.Equal(t, outcomeClientError, outcome(&pgconn.PgError{Code: "23505"}))
+	assert.Equal(t, outcomeError, outcome(&pgconn.PgError{Code: "42P01"}))
+	assert.Equal(t, outcomeError, outcome(context.DeadlineExceeded))
+}
+
+func TestLogSQL(t *testing.T) {
+	before := testutil.CollectAndCount(telemetry.SQLDuration)
+
+	span := fmt.Sprintf("testLogSQL%d", time.Now().UnixNano()) // a new series each run
+	logSQL(span, opExec, primaryPool, time.Now(), &pgconn.PgError{Code: "23503"})
+	assert.Equal(t, before+1, testutil.CollectAndCount(telemetry.SQLDuration))
+}
+
+func TestRegisterPools(t *testing.T) {
+	pool, err := pgxpool.New(context.Background(), "host=127.0.0.1 port=1 user=test pool_max_conns=3")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer pool.Close()
+
+	d := &Database{pool: pool, replicas: []*replica{{name: "replica-test", pool: pool}}}
+	d.registerPools()
+
+	expected := `
+# HELP kphs_db_pool_max_conns The maximum number of connections in the pool
+# TYPE kphs_db_pool_max_conns gauge
+kphs_db_pool_max_conns{pool="primary"} 3
+kphs_db_pool_max_conns{pool="replica-test"} 3
+`
+	assert.Nil(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "kphs_db_pool_max_conns"))
+}
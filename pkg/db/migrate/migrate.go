@@ -0,0 +1,351 @@
+// Package migrate applies versioned SQL migrations, embedded in the binary, to the database.
+// Applied versions are recorded (along with a checksum of each migration) in a schema table,
+// and a Postgres advisory lock ensures that only one instance migrates at a time.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kaphos/webapp/internal/log"
+	"github.com/kaphos/webapp/pkg/db"
+	"go.uber.org/zap"
+	"io/fs"
+	"time"
+)
+
+// Latest can be passed as a target version to migrate to the newest known migration.
+const Latest int64 = -1
+
+const defaultTable = "schema_migrations"
+
+var (
+	// ErrDatabaseAhead is returned if the database has a migration applied that is newer
+	// than any known to the binary (e.g. after deploying an older version).
+	ErrDatabaseAhead = errors.New("migrate: database schema is newer than this binary")
+	// ErrUnknownMigration is returned if the database has a migration applied that is not
+	// known to the binary, but is older than the newest known migration.
+	ErrUnknownMigration = errors.New("migrate: database has an applied migration unknown to this binary")
+	// ErrChecksumMismatch is returned if an applied migration has since been modified.
+	ErrChecksumMismatch = errors.New("migrate: applied migration has been modified")
+	// ErrNoDown is returned when rolling back a migration that has no down file.
+	ErrNoDown = errors.New("migrate: migration cannot be rolled back")
+)
+
+// Step is a single migration applied (or, in a dry run, to be applied) in one direction.
+type Step struct {
+	Migration
+	Up bool // false if the migration is being rolled back
+}
+
+func (s Step) String() string {
+	direction := "up"
+	if !s.Up {
+		direction = "down"
+	}
+	return fmt.Sprintf("%d (%s) %s", s.Version, s.Description, direction)
+}
+
+// Status describes a known migration, and whether it has been applied.
+type Status struct {
+	Migration
+	AppliedAt *time.Time // nil if not applied
+}
+
+type applied struct {
+	version   int64
+	checksum  string
+	appliedAt time.Time
+}
+
+// Migrator applies the migrations loaded from a filesystem. Should be created using New.
+type Migrator struct {
+	migrations []Migration
+	table      string
+	logger     *zap.Logger
+}
+
+// New loads the migrations in fsys (typically an embed.FS). Returns an error if the
+// migrations are not valid (e.g. duplicate versions, or badly named files).
+func New(fsys fs.FS) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		migrations: migrations,
+		table:      defaultTable,
+		logger:     log.Get("MIGRATE"),
+	}, nil
+}
+
+// SetTable sets the name of the table used to record applied migrations
+// (default "schema_migrations").
+func (m *Migrator) SetTable(table string) { m.table = table }
+
+// Migrations returns the known migrations, ordered by version.
+func (m *Migrator) Migrations() []Migration { return m.migrations }
+
+// Migrate brings the database to the target version (or Latest), applying pending migrations
+// or rolling back newer ones as needed. Each migration runs in its own transaction. If dryRun
+// is true, the steps are returned and logged, but not applied. Fails (without changing
+// anything) if Verify would.
+func (m *Migrator) Migrate(ctx context.Context, database *db.Database, target int64, dryRun bool) ([]Step, error) {
+	return m.migrate(ctx, database, dryRun, func(map[int64]applied) int64 { return target })
+}
+
+// migrate is as per Migrate, with the target computed from the applied migrations once the
+// lock is held, so that it cannot be based on a state that other instances have since changed.
+func (m *Migrator) migrate(ctx context.Context, database *db.Database, dryRun bool, target func(done map[int64]applied) int64) ([]Step, error) {
+	var steps []Step
+	err := m.withLock(ctx, database, func(conn *pgxpool.Conn) error {
+		if err := m.ensureTable(ctx, conn); err != nil {
+			return err
+		}
+
+		done, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verify(done); err != nil {
+			return err
+		}
+
+		steps, err = m.plan(done, target(done))
+		if err != nil {
+			return err
+		}
+
+		for _, step := range steps {
+			if dryRun {
+				m.logger.Info("Would migrate " + step.String())
+				continue
+			}
+
+			m.logger.Info("Migrating " + step.String())
+			if err := m.apply(ctx, conn, step); err != nil {
+				return fmt.Errorf("migrate: version %d: %w", step.Version, err)
+			}
+		}
+
+		return nil
+	})
+
+	return steps, err
+}
+
+// Rollback rolls back the given number of most recently applied migrations.
+// dryRun is as per Migrate.
+func (m *Migrator) Rollback(ctx context.Context, database *db.Database, steps int, dryRun bool) ([]Step, error) {
+	return m.migrate(ctx, database, dryRun, func(done map[int64]applied) int64 {
+		return m.rollbackTarget(done, steps)
+	})
+}
+
+// Verify returns an error if the database is not compatible with the known migrations:
+// ErrDatabaseAhead, ErrUnknownMigration or ErrChecksumMismatch. Pending migrations are not
+// treated as an error.
+func (m *Migrator) Verify(ctx context.Context, database *db.Database) error {
+	conn, err := database.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	done, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return m.verify(done)
+}
+
+// Status returns every known migration, along with when it was applied (if it was).
+func (m *Migrator) Status(ctx context.Context, database *db.Database) ([]Status, error) {
+	conn, err := database.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+
+	done, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Migration: migration}
+		if a, ok := done[migration.Version]; ok {
+			appliedAt := a.appliedAt
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return statuses, nil
+}
+
+// withLock runs f on a dedicated connection, holding an advisory lock so that other
+// instances wait for it to complete.
+func (m *Migrator) withLock(ctx context.Context, database *db.Database, f func(conn *pgxpool.Conn) error) error {
+	conn, err := database.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock(hashtext($1))", m.table); err != nil {
+		return err
+	}
+	defer func() {
+		// The lock must be released even if ctx has been cancelled
+		_, _ = conn.Exec(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", m.table)
+	}()
+
+	return f(conn)
+}
+
+func (m *Migrator) tableName() string {
+	return pgx.Identifier{m.table}.Sanitize()
+}
+
+func (m *Migrator) ensureTable(ctx context.Context, conn *pgxpool.Conn) error {
+	_, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+m.tableName()+` (
+		version     BIGINT PRIMARY KEY,
+		description TEXT NOT NULL,
+		checksum    TEXT NOT NULL,
+		applied_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	return err
+}
+
+// applied returns the migrations recorded as applied, keyed by version. If the
+// table does not yet exist, no migrations have been applied.
+func (m *Migrator) applied(ctx context.Context, conn *pgxpool.Conn) (map[int64]applied, error) {
+	done := make(map[int64]applied)
+
+	var exists bool
+	if err := conn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", m.tableName()).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return done, nil
+	}
+
+	rows, err := conn.Query(ctx, "SELECT version, checksum, applied_at FROM "+m.tableName())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a applied
+		if err := rows.Scan(&a.version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		done[a.version] = a
+	}
+
+	return done, rows.Err()
+}
+
+func (m *Migrator) latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) verify(done map[int64]applied) error {
+	known := make(map[int64]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+
+	for version, a := range done {
+		migration, ok := known[version]
+		switch {
+		case !ok && version > m.latest():
+			return fmt.Errorf("%w (version %d applied, binary has up to %d)", ErrDatabaseAhead, version, m.latest())
+		case !ok:
+			return fmt.Errorf("%w (version %d)", ErrUnknownMigration, version)
+		case migration.Checksum != a.checksum:
+			return fmt.Errorf("%w (version %d)", ErrChecksumMismatch, version)
+		}
+	}
+
+	return nil
+}
+
+// rollbackTarget returns the version to migrate to in order to roll back the given number of
+// most recently applied migrations (0 if that is all of them).
+func (m *Migrator) rollbackTarget(done map[int64]applied, steps int) int64 {
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if _, ok := done[m.migrations[i].Version]; !ok {
+			continue
+		}
+		if steps == 0 {
+			return m.migrations[i].Version
+		}
+		steps--
+	}
+	return 0
+}
+
+// plan returns the steps needed to bring the database to target: rolling back applied
+// migrations newer than target (newest first), then applying pending migrations up to
+// target (oldest first).
+func (m *Migrator) plan(done map[int64]applied, target int64) ([]Step, error) {
+	if target == Latest {
+		target = m.latest()
+	}
+
+	steps := make([]Step, 0)
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if _, ok := done[migration.Version]; ok && migration.Version > target {
+			if migration.Down == "" {
+				return nil, fmt.Errorf("%w (version %d has no down migration)", ErrNoDown, migration.Version)
+			}
+			steps = append(steps, Step{Migration: migration, Up: false})
+		}
+	}
+
+	for _, migration := range m.migrations {
+		if _, ok := done[migration.Version]; !ok && migration.Version <= target {
+			steps = append(steps, Step{Migration: migration, Up: true})
+		}
+	}
+
+	return steps, nil
+}
+
+// apply runs a single step, recording it in the schema table in the same transaction.
+func (m *Migrator) apply(ctx context.Context, conn *pgxpool.Conn, step Step) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }() // no-op once committed
+
+	if step.Up {
+		if _, err := tx.Exec(ctx, step.Migration.Up); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO "+m.tableName()+" (version, description, checksum) VALUES ($1, $2, $3)",
+			step.Version, step.Description, step.Checksum)
+	} else {
+		if _, err := tx.Exec(ctx, step.Migration.Down); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM "+m.tableName()+" WHERE version = $1", step.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,101 @@
+package migrate
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+)
+
+func testMigrator(t *testing.T) *Migrator {
+	m, err := New(fstest.MapFS{
+		"database/V1__Initial_schema.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+		"database/U1__Initial_schema.sql": {Data: []byte("DROP TABLE a;")},
+		"database/2_add_b.up.sql":         {Data: []byte("CREATE TABLE b (id INT);")},
+		"database/2_add_b.down.sql":       {Data: []byte("DROP TABLE b;")},
+		"database/V3__Seed.sql":           {Data: []byte("INSERT INTO a VALUES (1);")},
+		"database/docker-compose.yml":     {Data: []byte("services: {}")},
+	})
+	assert.Nil(t, err)
+	return m
+}
+
+func TestLoad(t *testing.T) {
+	m := testMigrator(t)
+
+	migrations := m.Migrations()
+	if assert.Len(t, migrations, 3) {
+		assert.Equal(t, int64(1), migrations[0].Version)
+		assert.Equal(t, "Initial schema", migrations[0].Description)
+		assert.Equal(t, "DROP TABLE a;", migrations[0].Down)
+		assert.Equal(t, "add b", migrations[1].Description)
+		assert.Equal(t, "CREATE TABLE b (id INT);", migrations[1].Up)
+		assert.Empty(t, migrations[2].Down)
+		assert.Len(t, migrations[2].Checksum, 64)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"bad name":  {"V1_initial.sql": {}},
+		"duplicate": {"V1__a.sql": {}, "1_b.up.sql": {}},
+		"no up":     {"U1__a.sql": {}},
+		"version 0": {"V0__a.sql": {}},
+	} {
+		_, err := New(fsys)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	m := testMigrator(t)
+	versions := func(steps []Step) []int64 {
+		v := make([]int64, len(steps))
+		for i, step := range steps {
+			v[i] = step.Version
+			if !step.Up {
+				v[i] = -step.Version
+			}
+		}
+		return v
+	}
+
+	steps, err := m.plan(map[int64]applied{}, Latest)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, versions(steps))
+
+	steps, err = m.plan(map[int64]applied{1: {}}, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{2}, versions(steps))
+
+	steps, err = m.plan(map[int64]applied{1: {}, 2: {}}, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []int64{-2, -1}, versions(steps))
+
+	_, err = m.plan(map[int64]applied{1: {}, 2: {}, 3: {}}, 2)
+	assert.True(t, errors.Is(err, ErrNoDown))
+}
+
+func TestRollbackTarget(t *testing.T) {
+	m := testMigrator(t)
+	done := map[int64]applied{1: {}, 3: {}}
+
+	assert.Equal(t, int64(3), m.rollbackTarget(done, 0))
+	assert.Equal(t, int64(1), m.rollbackTarget(done, 1))
+	assert.Equal(t, int64(0), m.rollbackTarget(done, 2))
+	assert.Equal(t, int64(0), m.rollbackTarget(done, 5))
+	assert.Equal(t, int64(0), m.rollbackTarget(map[int64]applied{}, 1))
+}
+
+func TestVerify(t *testing.T) {
+	m := testMigrator(t)
+	checksum := m.Migrations()[0].Checksum
+
+	assert.Nil(t, m.verify(map[int64]applied{}))
+	assert.Nil(t, m.verify(map[int64]applied{1: {checksum: checksum}}))
+	assert.True(t, errors.Is(m.verify(map[int64]applied{1: {checksum: "edited"}}), ErrChecksumMismatch))
+	assert.True(t, errors.Is(m.verify(map[int64]applied{4: {}}), ErrDatabaseAhead))
+
+	m.migrations = m.migrations[1:]
+	assert.True(t, errors.Is(m.verify(map[int64]applied{1: {}}), ErrUnknownMigration))
+}
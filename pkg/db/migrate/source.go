@@ -0,0 +1,111 @@
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Migration is a single versioned schema change, loaded from one or two SQL files.
+type Migration struct {
+	Version     int64
+	Description string
+	Up          string // SQL applied when migrating up
+	Down        string // SQL applied when rolling back; empty if there is no down file
+	Checksum    string // SHA-256 of Up, used to detect migrations edited after being applied
+}
+
+// Files can be named either "V<version>__<description>.sql" (up) and
+// "U<version>__<description>.sql" (down), as used by Flyway, or
+// "<version>_<description>.up.sql" and "<version>_<description>.down.sql".
+var (
+	flywayRegexp = regexp.MustCompile(`^([VU])(\d+)__(.+)\.sql$`)
+	suffixRegexp = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+)
+
+// parseFilename returns the version, description and direction of a migration file.
+func parseFilename(name string) (version int64, description string, up bool, err error) {
+	var versionStr string
+	if match := flywayRegexp.FindStringSubmatch(name); match != nil {
+		versionStr, description, up = match[2], match[3], match[1] == "V"
+	} else if match := suffixRegexp.FindStringSubmatch(name); match != nil {
+		versionStr, description, up = match[1], match[2], match[3] == "up"
+	} else {
+		return 0, "", false, fmt.Errorf("migrate: unrecognised migration filename %q", name)
+	}
+
+	version, err = strconv.ParseInt(versionStr, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, "", false, fmt.Errorf("migrate: invalid version in filename %q", name)
+	}
+
+	return version, strings.ReplaceAll(description, "_", " "), up, nil
+}
+
+// load reads every .sql file in fsys (including subdirectories) as a migration, returning
+// them ordered by version. Other files are ignored, but .sql files that are not named as
+// migrations are treated as an error, as they are most likely a typo.
+func load(fsys fs.FS) ([]Migration, error) {
+	byVersion := make(map[int64]*Migration)
+	hasDown := make(map[int64]bool)
+
+	err := fs.WalkDir(fsys, ".", func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || path.Ext(p) != ".sql" {
+			return err
+		}
+
+		version, description, up, err := parseFilename(path.Base(p))
+		if err != nil {
+			return err
+		}
+
+		contents, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Description: description}
+			byVersion[version] = m
+		}
+
+		if up {
+			if m.Checksum != "" {
+				return fmt.Errorf("migrate: more than one up migration for version %d", version)
+			}
+			m.Up = string(contents)
+			m.Description = description
+			sum := sha256.Sum256(contents)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			if hasDown[version] {
+				return fmt.Errorf("migrate: more than one down migration for version %d", version)
+			}
+			hasDown[version] = true
+			m.Down = string(contents)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Checksum == "" {
+			return nil, fmt.Errorf("migrate: version %d has a down migration, but no up migration", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
@@ -0,0 +1,72 @@
+package db
+
+import (
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/kaphos/webapp/pkg/pagination"
+	"strings"
+)
+
+// Paginate returns query, modified to only return the requested page, along with the updated
+// args. One row more than the limit is fetched, so that pagination.NewResponse can tell whether
+// there is a next page.
+//
+// In offset mode, LIMIT and OFFSET are appended to the query, which should already be ordered.
+// In keyset mode, the query is wrapped, then filtered and ordered by the Paginator's keys,
+// which must be among the columns returned by the query, and should not be nullable (as rows
+// with null keys cannot be compared against a cursor). Any ordering of the query itself is
+// overridden, so sort keys requested by the client must be made part of the Paginator's keys
+// (see filter.Query.Paginate).
+func Paginate(query string, page pagination.Page, args ...interface{}) (string, []interface{}) {
+	if page.Mode == pagination.OffsetMode {
+		query = fmt.Sprintf("%s LIMIT $%d OFFSET $%d", query, len(args)+1, len(args)+2)
+		return query, append(args, page.Limit+1, page.Cursor.Offset)
+	}
+
+	keys := make([]string, len(page.Keys))
+	ops := make([]string, len(page.Keys))
+	order := make([]string, len(page.Keys))
+	uniform := true
+	for i, key := range page.Keys {
+		keys[i] = pgx.Identifier{key}.Sanitize()
+
+		// Reading backwards flips both the comparison and the order; NewResponse then restores the order
+		ops[i], order[i] = ">", keys[i]+" ASC"
+		if page.KeyDescending(i) != page.Cursor.Backward {
+			ops[i], order[i] = "<", keys[i]+" DESC"
+		}
+		uniform = uniform && ops[i] == ops[0]
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT * FROM (" + query + ") AS page")
+
+	if page.Cursor.Values != nil {
+		placeholders := make([]string, len(page.Cursor.Values))
+		for i := range page.Cursor.Values {
+			placeholders[i] = fmt.Sprintf("$%d", len(args)+i+1)
+		}
+		args = append(args, page.Cursor.Values...)
+
+		if uniform {
+			sb.WriteString(fmt.Sprintf(" WHERE (%s) %s (%s)", strings.Join(keys, ", "), ops[0], strings.Join(placeholders, ", ")))
+		} else {
+			// Row comparisons only go in one direction, so compare key by key instead
+			clauses := make([]string, len(keys))
+			for i := range keys {
+				parts := make([]string, 0, i+1)
+				for j := 0; j < i; j++ {
+					parts = append(parts, keys[j]+" = "+placeholders[j])
+				}
+				parts = append(parts, keys[i]+" "+ops[i]+" "+placeholders[i])
+				clauses[i] = "(" + strings.Join(parts, " AND ") + ")"
+			}
+			sb.WriteString(" WHERE " + strings.Join(clauses, " OR "))
+		}
+	}
+
+	sb.WriteString(" ORDER BY " + strings.Join(order, ", "))
+	sb.WriteString(fmt.Sprintf(" LIMIT $%d", len(args)+1))
+
+	return sb.String(), append(args, page.Limit+1)
+}
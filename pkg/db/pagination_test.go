@@ -0,0 +1,51 @@
+package db
+
+import (
+	"github.com/kaphos/webapp/pkg/pagination"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPaginateOffset(t *testing.T) {
+	page := pagination.Page{Paginator: pagination.NewOffset(), Limit: 10, Cursor: pagination.Cursor{Offset: 30}}
+	query, args := Paginate("SELECT id FROM users WHERE admin = $1 ORDER BY id", page, true)
+
+	assert.Equal(t, "SELECT id FROM users WHERE admin = $1 ORDER BY id LIMIT $2 OFFSET $3", query)
+	assert.Equal(t, []interface{}{true, 11, 30}, args)
+}
+
+func TestPaginateKeyset(t *testing.T) {
+	paginator := pagination.NewKeyset(true, "created", "id")
+
+	query, args := Paginate("SELECT * FROM items", pagination.Page{Paginator: paginator, Limit: 5})
+	assert.Equal(t, `SELECT * FROM (SELECT * FROM items) AS page ORDER BY "created" DESC, "id" DESC LIMIT $1`, query)
+	assert.Equal(t, []interface{}{6}, args)
+
+	page := pagination.Page{Paginator: paginator, Limit: 5, Cursor: pagination.Cursor{Values: []interface{}{"2023-01-01", "abc"}}}
+	query, args = Paginate("SELECT * FROM items WHERE owner = $1", page, "me")
+	assert.Equal(t, `SELECT * FROM (SELECT * FROM items WHERE owner = $1) AS page WHERE ("created", "id") < ($2, $3) ORDER BY "created" DESC, "id" DESC LIMIT $4`, query)
+	assert.Equal(t, []interface{}{"me", "2023-01-01", "abc", 6}, args)
+
+	page.Cursor.Backward = true
+	query, _ = Paginate("SELECT * FROM items", page)
+	assert.Equal(t, `SELECT * FROM (SELECT * FROM items) AS page WHERE ("created", "id") > ($1, $2) ORDER BY "created" ASC, "id" ASC LIMIT $3`, query)
+}
+
+func TestPaginateKeysetMixed(t *testing.T) {
+	paginator := pagination.NewKeyset(false, "created", "name", "id")
+	paginator.KeysDescending = []bool{true, false, false}
+
+	query, _ := Paginate("SELECT * FROM items", pagination.Page{Paginator: paginator, Limit: 5})
+	assert.Equal(t, `SELECT * FROM (SELECT * FROM items) AS page ORDER BY "created" DESC, "name" ASC, "id" ASC LIMIT $1`, query)
+
+	page := pagination.Page{Paginator: paginator, Limit: 5, Cursor: pagination.Cursor{Values: []interface{}{"2023-01-01", "Desk", "7"}}}
+	query, args := Paginate("SELECT * FROM items", page)
+	assert.Equal(t, `SELECT * FROM (SELECT * FROM items) AS page WHERE ("created" < $1) OR ("created" = $1 AND "name" > $2) `+
+		`OR ("created" = $1 AND "name" = $2 AND "id" > $3) ORDER BY "created" DESC, "name" ASC, "id" ASC LIMIT $4`, query)
+	assert.Equal(t, []interface{}{"2023-01-01", "Desk", "7", 6}, args)
+
+	page.Cursor.Backward = true
+	query, _ = Paginate("SELECT * FROM items", page)
+	assert.Equal(t, `SELECT * FROM (SELECT * FROM items) AS page WHERE ("created" > $1) OR ("created" = $1 AND "name" < $2) `+
+		`OR ("created" = $1 AND "name" = $2 AND "id" < $3) ORDER BY "created" ASC, "name" DESC, "id" DESC LIMIT $4`, query)
+}
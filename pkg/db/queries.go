@@ -0,0 +1,294 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"io/fs"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var nameAnnotation = regexp.MustCompile(`^--\s*name:\s*(\S+)\s*$`)
+
+// scanSamples are encoded to produce a value of a column's type, which is scanned to check
+// that the column can be scanned into a target (see checkColumn). The first one that can be
+// encoded as the type is used.
+var scanSamples = []interface{}{int64(0), true, time.Unix(0, 0).UTC(), [16]byte{}, "0", pgtype.Interval{Valid: true}}
+
+// Column describes a parameter or result column of a prepared query.
+type Column struct {
+	Name string // empty for parameters
+	Type string // Postgres type name (e.g. "int4"), or its OID if unknown
+	OID  uint32
+}
+
+// NamedQuery is a query loaded from a .sql file (see LoadQueries). Its name doubles as its
+// span name, e.g. db.QueryAll[User](d, q.Name, ctx, q.SQL). Params and Columns are only set
+// once the queries have been prepared.
+type NamedQuery struct {
+	Name    string
+	SQL     string
+	File    string
+	Params  []Column
+	Columns []Column
+}
+
+// Queries is a registry of named queries.
+type Queries struct {
+	queries map[string]NamedQuery
+	scans   map[string]func(query NamedQuery, typeMap *pgtype.Map) error // see Scanned
+}
+
+// LoadQueries loads the named queries in each .sql file in fsys (typically an embed.FS).
+// Each query starts with a "-- name: <name>" line, and runs until the next one, e.g.:
+//
+//	-- name: getUsers
+//	SELECT id, name FROM users;
+//
+// Returns an error if a query is empty or its name is used more than once.
+func LoadQueries(fsys fs.FS) (*Queries, error) {
+	q := &Queries{queries: make(map[string]NamedQuery), scans: make(map[string]func(NamedQuery, *pgtype.Map) error)}
+
+	err := fs.WalkDir(fsys, ".", func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || path.Ext(filePath) != ".sql" {
+			return err
+		}
+
+		content, err := fs.ReadFile(fsys, filePath)
+		if err != nil {
+			return err
+		}
+		return q.parse(filePath, string(content))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// MustLoadQueries is similar to LoadQueries, but panics on error. For use when
+// initialising global variables.
+func MustLoadQueries(fsys fs.FS) *Queries {
+	q, err := LoadQueries(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+// parse adds the queries in a single file.
+func (q *Queries) parse(file, content string) error {
+	var current *NamedQuery
+	var body strings.Builder
+
+	add := func() error {
+		if current == nil {
+			return nil
+		}
+		current.SQL = strings.TrimSuffix(strings.TrimSpace(body.String()), ";")
+		if current.SQL == "" {
+			return fmt.Errorf("db: query %s in %s is empty", current.Name, file)
+		}
+		q.queries[current.Name] = *current
+		return nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+
+		if match := nameAnnotation.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			if err := add(); err != nil {
+				return err
+			}
+			if existing, ok := q.queries[match[1]]; ok {
+				return fmt.Errorf("db: query %s in %s is already defined in %s", match[1], file, existing.File)
+			}
+			current = &NamedQuery{Name: match[1], File: file}
+			body.Reset()
+			continue
+		}
+
+		if current == nil {
+			if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "--") {
+				return fmt.Errorf("db: %s:%d is not part of a named query (missing \"-- name:\")", file, lineNo)
+			}
+			continue
+		}
+
+		body.WriteString(line)
+		body.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return add()
+}
+
+// Get returns a named query. Panics if no query was loaded with that name, as that is
+// a programming error.
+func (q *Queries) Get(name string) NamedQuery {
+	query, ok := q.queries[name]
+	if !ok {
+		panic("db: no query named '" + name + "'")
+	}
+	return query
+}
+
+// Scanned returns a named query (see Get), and records that its rows are scanned into a T,
+// so that Prepare also checks that they can be (see CheckScan). For use when initialising
+// global variables, e.g.:
+//
+//	var getUsers = db.Scanned[User](queries, "getUsers")
+//
+//	users, err := db.QueryAll[User](r.DB, getUsers.Name, ctx, getUsers.SQL)
+func Scanned[T any](q *Queries, name string) NamedQuery {
+	query := q.Get(name)
+	q.scans[name] = checkScan[T]
+	return query
+}
+
+// Names returns the names of all the queries loaded, sorted.
+func (q *Queries) Names() []string {
+	names := make([]string, 0, len(q.queries))
+	for name := range q.queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Prepare prepares every query against the database, recording their parameter and
+// result column types, and checks that the rows of queries registered using Scanned can be
+// scanned into their targets. Returns an error listing every query that failed to prepare
+// (e.g. due to a syntax error, or a missing table or column) or to be checked.
+func (q *Queries) Prepare(ctx context.Context, d *Database) error {
+	conn, err := d.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	typeMap := conn.Conn().TypeMap()
+	typeName := func(oid uint32) string {
+		if t, ok := typeMap.TypeForOID(oid); ok {
+			return t.Name
+		}
+		return fmt.Sprint(oid)
+	}
+
+	failures := make([]string, 0)
+	for _, name := range q.Names() {
+		query := q.queries[name]
+
+		// Unnamed, so that the statement is not kept on the connection
+		desc, err := conn.Conn().PgConn().Prepare(ctx, "", query.SQL, nil)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s (%s): %s", name, query.File, err))
+			continue
+		}
+
+		query.Params = make([]Column, len(desc.ParamOIDs))
+		for i, oid := range desc.ParamOIDs {
+			query.Params[i] = Column{Type: typeName(oid), OID: oid}
+		}
+		query.Columns = make([]Column, len(desc.Fields))
+		for i, field := range desc.Fields {
+			query.Columns[i] = Column{Name: field.Name, Type: typeName(field.DataTypeOID), OID: field.DataTypeOID}
+		}
+		q.queries[name] = query
+
+		if check, ok := q.scans[name]; ok {
+			if err := check(query, typeMap); err != nil {
+				failures = append(failures, fmt.Sprintf("%s (%s): %s", name, query.File, err))
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("db: %d queries failed to prepare or check:\n%s", len(failures), strings.Join(failures, "\n"))
+	}
+	d.logger.Info(fmt.Sprintf("Prepared %d queries.", len(q.queries)))
+	return nil
+}
+
+// CheckScan reports whether the rows returned by a prepared query can be scanned into a T
+// (see QueryAll): if T is a struct, every column must map to one of its fields; otherwise,
+// the query must return a single column. Each column's type must also be scannable into the
+// Go type it maps to (e.g. a text column cannot be scanned into an int). Only types built
+// into pgx are known, so other columns (e.g. enums) are scanned as text.
+func CheckScan[T any](query NamedQuery) error {
+	return checkScan[T](query, pgtype.NewMap())
+}
+
+func checkScan[T any](query NamedQuery, typeMap *pgtype.Map) error {
+	if query.Columns == nil {
+		return fmt.Errorf("db: query %s has not been prepared", query.Name)
+	}
+
+	val := reflect.ValueOf(new(T)).Elem()
+	if !mapsColumns(val.Type()) {
+		if len(query.Columns) != 1 {
+			return fmt.Errorf("db: query %s returns %d columns, but %s can only be scanned from one", query.Name, len(query.Columns), val.Type())
+		}
+		return checkColumn(typeMap, query, query.Columns[0], val.Addr().Interface())
+	}
+
+	fields := make([]pgconn.FieldDescription, len(query.Columns))
+	for i, column := range query.Columns {
+		fields[i] = pgconn.FieldDescription{Name: column.Name, DataTypeOID: column.OID}
+	}
+	dest, err := scanTargets(val, fields)
+	if err != nil {
+		return fmt.Errorf("db: query %s: %w", query.Name, err)
+	}
+	for i, column := range query.Columns {
+		if err := checkColumn(typeMap, query, column, dest[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkColumn reports whether a column can be scanned into dest, by scanning a sample value of
+// its type (see sampleValue). Pointers (e.g. for nullable columns) are checked against the type
+// they point to. Columns without a sample value cannot be checked, and are assumed to be fine.
+func checkColumn(typeMap *pgtype.Map, query NamedQuery, column Column, dest interface{}) error {
+	if elem := reflect.TypeOf(dest).Elem(); elem.Kind() == reflect.Pointer {
+		dest = reflect.New(elem.Elem()).Interface()
+	}
+
+	format := typeMap.FormatCodeForOID(column.OID)
+	src := sampleValue(typeMap, column.OID, format)
+	if src == nil {
+		return nil
+	}
+	if err := typeMap.PlanScan(column.OID, format, dest).Scan(src, dest); err != nil {
+		return fmt.Errorf("db: query %s: column %q (%s) cannot be scanned into %s", query.Name, column.Name, column.Type, reflect.TypeOf(dest).Elem())
+	}
+	return nil
+}
+
+// sampleValue returns a value of the given type, encoded from the first of scanSamples that
+// can be, or nil if none can. JSON is sampled as null, which can be scanned into any target.
+func sampleValue(typeMap *pgtype.Map, oid uint32, format int16) []byte {
+	samples := scanSamples
+	if oid == pgtype.JSONOID || oid == pgtype.JSONBOID {
+		samples = []interface{}{[]byte("null")}
+	}
+	for _, sample := range samples {
+		if src, err := typeMap.Encode(oid, format, sample, nil); err == nil && src != nil {
+			return src
+		}
+	}
+	return nil
+}
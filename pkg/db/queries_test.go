@@ -0,0 +1,123 @@
+package db
+
+import (
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadQueries(t *testing.T) {
+	fsys := fstest.MapFS{
+		"users.sql": {Data: []byte(`-- Queries for users
+-- name: getUsers
+SELECT id, name
+FROM users;
+
+-- name: deleteUser
+DELETE FROM users WHERE id = $1
+`)},
+		"nested/tags.sql": {Data: []byte("--name: getTags\nSELECT * FROM tags\n")},
+		"README.md":       {Data: []byte("not a query")},
+	}
+
+	q, err := LoadQueries(fsys)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, []string{"deleteUser", "getTags", "getUsers"}, q.Names())
+	assert.Equal(t, NamedQuery{Name: "getUsers", SQL: "SELECT id, name\nFROM users", File: "users.sql"}, q.Get("getUsers"))
+	assert.Equal(t, "DELETE FROM users WHERE id = $1", q.Get("deleteUser").SQL)
+	assert.Equal(t, "nested/tags.sql", q.Get("getTags").File)
+	assert.Panics(t, func() { q.Get("getItems") })
+}
+
+func TestLoadQueriesInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"missing name": "SELECT 1",
+		"empty":        "-- name: getUsers\n\n-- name: getItems\nSELECT 1",
+	} {
+		_, err := LoadQueries(fstest.MapFS{"queries.sql": {Data: []byte(content)}})
+		assert.NotNil(t, err, name)
+	}
+
+	_, err := LoadQueries(fstest.MapFS{
+		"a.sql": {Data: []byte("-- name: getUsers\nSELECT 1")},
+		"b.sql": {Data: []byte("-- name: getUsers\nSELECT 2")},
+	})
+	assert.ErrorContains(t, err, "already defined in a.sql")
+}
+
+func TestCheckScan(t *testing.T) {
+	type user struct {
+		ID      int       `db:"id"`
+		Name    string    `json:"name"`
+		Email   *string   `json:"email"`
+		Created time.Time `json:"created"`
+	}
+
+	query := NamedQuery{Name: "getUsers", Columns: []Column{
+		{Name: "id", Type: "int4", OID: pgtype.Int4OID},
+		{Name: "name", Type: "text", OID: pgtype.TextOID},
+		{Name: "email", Type: "varchar", OID: pgtype.VarcharOID},
+		{Name: "created", Type: "timestamptz", OID: pgtype.TimestamptzOID},
+	}}
+	assert.Nil(t, CheckScan[user](query))
+	assert.NotNil(t, CheckScan[int](query))
+
+	query.Columns = append(query.Columns, Column{Name: "admin", Type: "bool", OID: pgtype.BoolOID})
+	assert.ErrorContains(t, CheckScan[user](query), `column "admin"`)
+
+	query.Columns = []Column{{Name: "id", Type: "uuid", OID: pgtype.UUIDOID}}
+	assert.ErrorContains(t, CheckScan[user](query), `column "id" (uuid) cannot be scanned into int`)
+	query.Columns = []Column{{Name: "email", Type: "int4", OID: pgtype.Int4OID}, {Name: "name", Type: "text", OID: pgtype.TextOID}}
+	assert.Nil(t, CheckScan[user](query)) // ints can be scanned into strings
+	query.Columns = []Column{{Name: "created", Type: "text", OID: pgtype.TextOID}}
+	assert.ErrorContains(t, CheckScan[user](query), `column "created" (text) cannot be scanned into time.Time`)
+
+	count := NamedQuery{Name: "countUsers", Columns: []Column{{Name: "count", Type: "int8", OID: pgtype.Int8OID}}}
+	assert.Nil(t, CheckScan[int64](count))
+	assert.ErrorContains(t, CheckScan[bool](count), `column "count" (int8) cannot be scanned into bool`)
+
+	type settings struct {
+		Theme string `json:"theme"`
+	}
+	type profile struct {
+		ID       string   `json:"id"`
+		Settings settings `json:"settings"`
+		Tags     []string `json:"tags"`
+	}
+	profiles := NamedQuery{Name: "getProfiles", Columns: []Column{
+		{Name: "id", Type: "uuid", OID: pgtype.UUIDOID},
+		{Name: "settings", Type: "jsonb", OID: pgtype.JSONBOID},
+		{Name: "tags", Type: "_text", OID: pgtype.TextArrayOID},
+	}}
+	assert.Nil(t, CheckScan[profile](profiles))
+	profiles.Columns[1] = Column{Name: "settings", Type: "int4", OID: pgtype.Int4OID}
+	assert.ErrorContains(t, CheckScan[profile](profiles), `column "settings" (int4) cannot be scanned into`)
+	count.Columns[0] = Column{Name: "count", Type: "text", OID: pgtype.TextOID}
+	assert.ErrorContains(t, CheckScan[int](count), `column "count" (text) cannot be scanned into int`)
+
+	assert.NotNil(t, CheckScan[user](NamedQuery{Name: "unprepared"}))
+}
+
+func TestScanned(t *testing.T) {
+	q, err := LoadQueries(fstest.MapFS{"users.sql": {Data: []byte("-- name: countUsers\nSELECT count(*) FROM users")}})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, q.Get("countUsers"), Scanned[int64](q, "countUsers"))
+	assert.Panics(t, func() { Scanned[int64](q, "getUsers") })
+
+	check := q.scans["countUsers"]
+	if assert.NotNil(t, check) {
+		query := q.Get("countUsers")
+		query.Columns = []Column{{Name: "count", Type: "int8", OID: pgtype.Int8OID}}
+		assert.Nil(t, check(query, pgtype.NewMap()))
+		query.Columns[0] = Column{Name: "count", Type: "bool", OID: pgtype.BoolOID}
+		assert.NotNil(t, check(query, pgtype.NewMap()))
+	}
+}
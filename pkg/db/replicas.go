@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/kaphos/webapp/internal/telemetry"
+	"github.com/kaphos/webapp/pkg/utils"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	primaryPool = "primary"
+
+	// RoundRobin spreads reads evenly across healthy replicas.
+	RoundRobin = "round-robin"
+	// LeastConns sends each read to the healthy replica with the fewest connections in use.
+	LeastConns = "least-conns"
+
+	defaultReplicaCheckInterval = 5 * time.Second
+)
+
+// replica is a connection pool to a read replica, which is ejected while it is unhealthy.
+type replica struct {
+	name    string
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+type primaryKey struct{}
+
+type scopeKey struct{}
+
+// requestScope tracks database usage across a single request.
+type requestScope struct {
+	wrote    atomic.Bool  // set once a write is made, so that later reads go to the primary
+	queries  atomic.Int64 // number of queries made (see QueryStats)
+	duration atomic.Int64 // total time spent on queries, in nanoseconds
+}
+
+// UsePrimary returns a context whose queries always go to the primary, for reads that must
+// see the latest data, and for SELECT statements that write (e.g. by calling a volatile
+// function such as nextval), which would otherwise be sent to a replica.
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// WithRequestScope returns a context that tracks database usage across a request. Once a
+// write is made using it (or a context derived from it), later reads also go to the primary,
+// so that the request sees its own writes. Also counts the queries made (see QueryStats).
+// Applied by the Server to every request.
+func WithRequestScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scopeKey{}, &requestScope{})
+}
+
+func scopeFrom(ctx context.Context) *requestScope {
+	scope, _ := ctx.Value(scopeKey{}).(*requestScope)
+	return scope
+}
+
+// markWrite records that a write was made within the request (if any).
+func markWrite(ctx context.Context) {
+	if scope := scopeFrom(ctx); scope != nil {
+		scope.wrote.Store(true)
+	}
+}
+
+// lockingClause matches the row-locking clauses of SELECT statements, which replicas reject.
+var lockingClause = regexp.MustCompile(`(?i)\bFOR\s+(UPDATE|SHARE|NO\s+KEY\s+UPDATE|KEY\s+SHARE)\b`)
+
+// readOnly returns true if the query can be sent to a replica. Only plain SELECT statements
+// are, without a locking clause (e.g. FOR UPDATE). Whether a statement calls volatile
+// functions cannot be told from its text, so statements that start with SELECT but write
+// (e.g. "SELECT nextval('ids')", or calls to functions that modify tables) must be run with
+// a context from UsePrimary.
+func readOnly(query string) bool {
+	query = strings.TrimSpace(query)
+	return len(query) >= 6 && strings.EqualFold(query[:6], "SELECT") && !lockingClause.MatchString(query)
+}
+
+// connectReplicas creates a pool for each of the comma-separated connection strings in
+// DB_REPLICA_URLS (if any), tuned and secured the same way as the primary's, and starts
+// checking their health.
+func (d *Database) connectReplicas(settings map[string]string, maxConns int32) error {
+	urls, err := utils.GetSecret("DB_REPLICA_URLS", "")
+	if err != nil || urls == "" {
+		return err
+	}
+
+	d.strategy = utils.GetEnv("DB_REPLICA_STRATEGY", RoundRobin)
+	if d.strategy != RoundRobin && d.strategy != LeastConns {
+		return fmt.Errorf("unknown DB_REPLICA_STRATEGY \"%s\"", d.strategy)
+	}
+
+	for i, url := range strings.Split(urls, ",") {
+		connStr, err := withTLSParams(strings.TrimSpace(url))
+		if err != nil {
+			return fmt.Errorf("invalid replica %d: %w", i, err)
+		}
+		config, err := pgxpool.ParseConfig(connStr)
+		if err != nil {
+			return fmt.Errorf("invalid replica %d: %w", i, err)
+		}
+		d.tunePool(config, maxConns)
+		config.AfterConnect = applySettings(settings)
+
+		pool, err := pgxpool.NewWithConfig(context.Background(), config)
+		if err != nil {
+			return err
+		}
+
+		r := &replica{name: fmt.Sprintf("replica-%d", i), pool: pool}
+		r.healthy.Store(true)
+		d.replicas = append(d.replicas, r)
+	}
+
+	interval := d.parseTimeout("DB_REPLICA_CHECK_INTERVAL", defaultReplicaCheckInterval)
+	go d.monitorReplicas(interval)
+
+	d.logger.Info(fmt.Sprintf("Using %d read replica(s) (%s).", len(d.replicas), d.strategy))
+	return nil
+}
+
+// monitorReplicas pings each replica periodically, ejecting it while it is unreachable.
+func (d *Database) monitorReplicas(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, r := range d.replicas {
+			ctx, cancel := context.WithTimeout(d.closing, interval)
+			d.setHealthy(r, r.pool.Ping(ctx) == nil)
+			cancel()
+		}
+
+		select {
+		case <-d.closing.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Database) setHealthy(r *replica, healthy bool) {
+	if r.healthy.Swap(healthy) != healthy {
+		if healthy {
+			d.logger.Info("Replica " + r.name + " is healthy again.")
+		} else {
+			d.logger.Warn("Replica " + r.name + " is unhealthy; sending its reads to other pools.")
+		}
+	}
+
+	val := 0.0
+	if healthy {
+		val = 1
+	}
+	telemetry.DBPoolHealthy.WithLabelValues(r.name).Set(val)
+}
+
+// checkReplicaError ejects a replica if a query on it failed for reasons other than the
+// query itself or scanning its results (e.g. the connection was refused), until it is next
+// found to be healthy.
+func (d *Database) checkReplicaError(r *replica, err error) {
+	var pgErr *pgconn.PgError
+	var scanErr pgx.ScanArgError
+	if err == nil || errors.As(err, &pgErr) || errors.As(err, &scanErr) || errors.Is(err, pgx.ErrNoRows) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	d.setHealthy(r, false)
+}
+
+// replica returns a healthy replica selected using the configured strategy,
+// or nil if there are none.
+func (d *Database) replica() *replica {
+	if len(d.replicas) == 0 {
+		return nil
+	}
+
+	if d.strategy == LeastConns {
+		var best *replica
+		for _, r := range d.replicas {
+			if r.healthy.Load() && (best == nil || r.pool.Stat().AcquiredConns() < best.pool.Stat().AcquiredConns()) {
+				best = r
+			}
+		}
+		return best
+	}
+
+	start := d.next.Add(1)
+	for i := 0; i < len(d.replicas); i++ {
+		r := d.replicas[(start+uint64(i))%uint64(len(d.replicas))]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// route returns where a query should run: the transaction carried by ctx, if any; otherwise
+// a replica, if the query is read-only and the primary is not required; otherwise the primary.
+// Also returns the name of the pool (for metrics), and the replica, if one was selected.
+func (d *Database) route(ctx context.Context, query string) (querier, string, *replica) {
+	if tx, ok := d.txFor(ctx); ok {
+		return tx, primaryPool, nil
+	}
+
+	if !readOnly(query) {
+		markWrite(ctx)
+		return d.pool, primaryPool, nil
+	}
+
+	if forced, _ := ctx.Value(primaryKey{}).(bool); forced {
+		return d.pool, primaryPool, nil
+	}
+	if scope := scopeFrom(ctx); scope != nil && scope.wrote.Load() {
+		return d.pool, primaryPool, nil
+	}
+
+	if r := d.replica(); r != nil {
+		return r.pool, r.name, r
+	}
+	return d.pool, primaryPool, nil
+}
+
+// Close stops checking the health of replicas, and closes all connections.
+func (d *Database) Close() {
+	d.close()
+	for _, r := range d.replicas {
+		r.pool.Close()
+	}
+	d.pool.Close()
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+)
+
+func testReplicas() *Database {
+	d := &Database{logger: zap.NewNop(), strategy: RoundRobin}
+	for _, name := range []string{"replica-0", "replica-1"} {
+		r := &replica{name: name}
+		r.healthy.Store(true)
+		d.replicas = append(d.replicas, r)
+	}
+	return d
+}
+
+func TestReadOnly(t *testing.T) {
+	assert.True(t, readOnly("SELECT * FROM users"))
+	assert.True(t, readOnly("\n\t select 1"))
+	assert.False(t, readOnly("INSERT INTO users (name) VALUES ($1) RETURNING id"))
+	assert.False(t, readOnly("WITH deleted AS (DELETE FROM users RETURNING id) SELECT count(*) FROM deleted"))
+	assert.False(t, readOnly("SEL"))
+
+	assert.False(t, readOnly("SELECT * FROM users WHERE id = $1 FOR UPDATE"))
+	assert.False(t, readOnly("SELECT * FROM users FOR NO KEY UPDATE SKIP LOCKED"))
+	assert.False(t, readOnly("select * from users for share"))
+	assert.False(t, readOnly("SELECT * FROM users\nFOR KEY SHARE OF users"))
+	assert.True(t, readOnly("SELECT * FROM users WHERE name = 'for updates'"))
+}
+
+func TestConnectReplicasTLS(t *testing.T) {
+	clearDBEnv(t)
+	t.Setenv("DB_REPLICA_URLS", "postgres://app@127.0.0.1:1/appdb?sslmode=disable, postgres://app@127.0.0.1:2/appdb")
+	t.Setenv("DB_SSLMODE", "require")
+
+	d := &Database{logger: zap.NewNop()}
+	d.closing, d.close = context.WithCancel(context.Background())
+	d.pool, _ = pgxpool.New(context.Background(), "postgres://app@127.0.0.1:1/appdb")
+	defer d.Close()
+
+	assert.Nil(t, d.connectReplicas(nil, 4))
+	assert.Len(t, d.replicas, 2)
+	for _, r := range d.replicas {
+		assert.NotNil(t, r.pool.Config().ConnConfig.TLSConfig)
+	}
+}
+
+func TestRouteRoundRobin(t *testing.T) {
+	d := testReplicas()
+
+	_, first, _ := d.route(context.Background(), "SELECT 1")
+	_, second, _ := d.route(context.Background(), "SELECT 1")
+	assert.ElementsMatch(t, []string{"replica-0", "replica-1"}, []string{first, second})
+
+	d.setHealthy(d.replicas[0], false)
+	for i := 0; i < 3; i++ {
+		_, pool, _ := d.route(context.Background(), "SELECT 1")
+		assert.Equal(t, "replica-1", pool)
+	}
+
+	d.setHealthy(d.replicas[1], false)
+	_, pool, r := d.route(context.Background(), "SELECT 1")
+	assert.Equal(t, primaryPool, pool)
+	assert.Nil(t, r)
+}
+
+func TestRoutePrimary(t *testing.T) {
+	d := testReplicas()
+
+	_, pool, _ := d.route(UsePrimary(context.Background()), "SELECT 1")
+	assert.Equal(t, primaryPool, pool)
+
+	ctx := WithRequestScope(context.Background())
+	_, pool, _ = d.route(ctx, "SELECT 1")
+	assert.NotEqual(t, primaryPool, pool)
+
+	_, pool, _ = d.route(ctx, "UPDATE users SET admin = true")
+	assert.Equal(t, primaryPool, pool)
+
+	// Read-your-writes: reads after the write go to the primary too
+	_, pool, _ = d.route(ctx, "SELECT 1")
+	assert.Equal(t, primaryPool, pool)
+
+	_, pool, _ = d.route(WithRequestScope(context.Background()), "SELECT 1")
+	assert.NotEqual(t, primaryPool, pool)
+}
+
+func TestCheckReplicaError(t *testing.T) {
+	d := testReplicas()
+	r := d.replicas[0]
+
+	d.checkReplicaError(r, &pgconn.PgError{Code: "42P01"})
+	d.checkReplicaError(r, context.DeadlineExceeded)
+	d.checkReplicaError(r, pgx.ErrNoRows)
+	d.checkReplicaError(r, pgx.ScanArgError{ColumnIndex: 0, Err: errors.New("cannot scan text into *int")})
+	assert.True(t, r.healthy.Load())
+
+	d.checkReplicaError(r, errors.New("dial tcp: connection refused"))
+	assert.False(t, r.healthy.Load())
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kaphos/webapp/internal/structmap"
+	"github.com/kaphos/webapp/pkg/errchk"
+	"reflect"
+	"time"
+)
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// QueryAll performs a database query, scanning every row into a T. If T is a struct, columns
+// are mapped to its fields by name (see the structmap package: `db` tag, falling back to the
+// json name), and every column must map to a field. Otherwise (e.g. a string, or a null.*
+// type), each row must have a single column. The span, timeout and rows are closed before
+// returning, and errors are handled using the errchk package.
+func QueryAll[T any](d DB, spanName string, ctx context.Context, query string, args ...interface{}) ([]T, error) {
+	rows, cancel, err := d.Query(spanName, ctx, query, args...)
+	defer cancel()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]T, 0)
+	for rows.Next() {
+		result, err := scanRow[T](rows)
+		err = convertUserError(err)
+		if errchk.HaveError(err, spanName) {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	err = convertUserError(rows.Err())
+	errchk.Check(err, spanName)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// QueryOne is similar to QueryAll, but reads and returns only the first row.
+// Returns errchk.ErrNoRows if the query did not return any rows.
+func QueryOne[T any](d DB, spanName string, ctx context.Context, query string, args ...interface{}) (T, error) {
+	return queryFirst(d, spanName, ctx, query, args, scanRow[T])
+}
+
+// QueryScalar performs a database query that returns a single value (e.g. a count), which is
+// scanned directly into a T, even if T is a struct. Returns errchk.ErrNoRows if the query did
+// not return any rows.
+func QueryScalar[T any](d DB, spanName string, ctx context.Context, query string, args ...interface{}) (T, error) {
+	return queryFirst(d, spanName, ctx, query, args, func(rows pgx.Rows) (T, error) {
+		var result T
+		return result, rows.Scan(&result)
+	})
+}
+
+// queryFirst performs a database query, scanning only its first row using scan.
+func queryFirst[T any](d DB, spanName string, ctx context.Context, query string, args []interface{}, scan func(pgx.Rows) (T, error)) (T, error) {
+	rows, cancel, err := d.Query(spanName, ctx, query, args...)
+	defer cancel()
+	if err != nil {
+		return *new(T), err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		err = convertUserError(rows.Err())
+		errchk.Check(err, spanName)
+		if err != nil {
+			return *new(T), err
+		}
+		return *new(T), errchk.ErrNoRows
+	}
+
+	result, err := scan(rows)
+	err = convertUserError(err)
+	if err == nil {
+		rows.Close() // reads any remaining rows, so that errors encountered doing so are reported
+		err = convertUserError(rows.Err())
+	}
+	errchk.Check(err, spanName)
+	return result, err
+}
+
+// scanRow scans the current row into a new T.
+func scanRow[T any](rows pgx.Rows) (T, error) {
+	var result T
+
+	val := reflect.ValueOf(&result).Elem()
+	if !mapsColumns(val.Type()) {
+		return result, rows.Scan(&result)
+	}
+
+	dest, err := scanTargets(val, rows.FieldDescriptions())
+	if err != nil {
+		return result, err
+	}
+	return result, rows.Scan(dest...)
+}
+
+// mapsColumns returns true if columns should be mapped to the fields of t,
+// rather than t being scanned into directly.
+func mapsColumns(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && !reflect.PointerTo(t).Implements(scannerType)
+}
+
+// scanTargets returns pointers to the fields of val (a struct) that each column maps to.
+func scanTargets(val reflect.Value, columns []pgconn.FieldDescription) ([]interface{}, error) {
+	byColumn := structmap.ByColumn(val.Type())
+
+	dest := make([]interface{}, len(columns))
+	for i, column := range columns {
+		field, ok := byColumn[column.Name]
+		if !ok {
+			return nil, fmt.Errorf("db: column %q does not map to a field of %s", column.Name, val.Type())
+		}
+		dest[i] = val.FieldByIndex(field.Index).Addr().Interface()
+	}
+
+	return dest, nil
+}
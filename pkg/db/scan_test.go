@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kaphos/webapp/pkg/errchk"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v4"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type scanBase struct {
+	ID      int       `json:"id"`
+	Created time.Time `json:"created"`
+}
+
+type scanUser struct {
+	scanBase
+	Name     string      `json:"name"`
+	Email    null.String `json:"email" db:"email_address"`
+	Password string      `json:"-" db:"password"`
+}
+
+func TestMapsColumns(t *testing.T) {
+	assert.True(t, mapsColumns(reflect.TypeOf(scanUser{})))
+	assert.False(t, mapsColumns(reflect.TypeOf(time.Time{})))
+	assert.False(t, mapsColumns(reflect.TypeOf(null.String{})))
+	assert.False(t, mapsColumns(reflect.TypeOf(0)))
+}
+
+func TestScanTargets(t *testing.T) {
+	var user scanUser
+	val := reflect.ValueOf(&user).Elem()
+
+	columns := []pgconn.FieldDescription{{Name: "email_address"}, {Name: "id"}, {Name: "password"}, {Name: "created"}}
+	dest, err := scanTargets(val, columns)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{&user.Email, &user.ID, &user.Password, &user.Created}, dest)
+
+	_, err = scanTargets(val, []pgconn.FieldDescription{{Name: "id"}, {Name: "age"}})
+	assert.NotNil(t, err)
+}
+
+// scalarRows returns a single row, failing to scan it with scanErr, or failing after it with err.
+type scalarRows struct {
+	pgx.Rows
+	scanErr, err error
+	read         bool
+}
+
+func (r *scalarRows) Next() bool                { next := !r.read; r.read = true; return next }
+func (r *scalarRows) Scan(...interface{}) error { return r.scanErr }
+func (r *scalarRows) Err() error                { return r.err }
+func (r *scalarRows) Close()                    {}
+
+func (r *scalarRows) FieldDescriptions() []pgconn.FieldDescription {
+	return []pgconn.FieldDescription{{Name: "id"}}
+}
+
+type scalarDB struct {
+	DB
+	rows *scalarRows
+}
+
+func (d scalarDB) Query(string, context.Context, string, ...interface{}) (pgx.Rows, func(), error) {
+	return d.rows, func() {}, nil
+}
+
+func TestQueryScalarErrors(t *testing.T) {
+	_, err := QueryScalar[int](scalarDB{rows: &scalarRows{scanErr: context.DeadlineExceeded}}, "scalar", context.Background(), "SELECT 1")
+	assert.Equal(t, errchk.ErrTimeout, err)
+
+	_, err = QueryScalar[int](scalarDB{rows: &scalarRows{err: context.DeadlineExceeded}}, "scalar", context.Background(), "SELECT 1")
+	assert.Equal(t, errchk.ErrTimeout, err)
+
+	_, err = QueryScalar[int](scalarDB{rows: &scalarRows{}}, "scalar", context.Background(), "SELECT 1")
+	assert.Nil(t, err)
+}
+
+func TestQueryOneErrors(t *testing.T) {
+	_, err := QueryOne[scanBase](scalarDB{rows: &scalarRows{scanErr: context.DeadlineExceeded}}, "one", context.Background(), "SELECT 1")
+	assert.Equal(t, errchk.ErrTimeout, err)
+
+	_, err = QueryOne[scanBase](scalarDB{rows: &scalarRows{err: context.DeadlineExceeded}}, "one", context.Background(), "SELECT 1")
+	assert.Equal(t, errchk.ErrTimeout, err)
+
+	_, err = QueryOne[scanBase](scalarDB{rows: &scalarRows{read: true}}, "one", context.Background(), "SELECT 1")
+	assert.Equal(t, errchk.ErrNoRows, err)
+
+	_, err = QueryOne[scanBase](scalarDB{rows: &scalarRows{}}, "one", context.Background(), "SELECT 1")
+	assert.Nil(t, err)
+}
+
+func TestQueryAllErrors(t *testing.T) {
+	_, err := QueryAll[scanBase](scalarDB{rows: &scalarRows{scanErr: context.DeadlineExceeded}}, "all", context.Background(), "SELECT 1")
+	assert.Equal(t, errchk.ErrTimeout, err)
+
+	results, err := QueryAll[scanBase](scalarDB{rows: &scalarRows{}}, "all", context.Background(), "SELECT 1")
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+}
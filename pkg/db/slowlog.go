@@ -0,0 +1,133 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/kaphos/webapp/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultSlowQueryThreshold = 500 * time.Millisecond
+
+// execution describes a single query, once it has completed.
+type execution struct {
+	spanName  string
+	operation string
+	pool      string
+	q         querier // where the query ran, so that it can be explained (nil if it cannot be)
+	query     string
+	args      []interface{}
+	start     time.Time
+	rows      int64
+	err       error // as returned by pgx, before conversion
+}
+
+// QueryStats returns the number of queries made using ctx (which must have been created
+// using WithRequestScope) and the total time spent on them.
+func QueryStats(ctx context.Context) (int64, time.Duration) {
+	scope := scopeFrom(ctx)
+	if scope == nil {
+		return 0, 0
+	}
+	return scope.queries.Load(), time.Duration(scope.duration.Load())
+}
+
+// parseExplainRate reads DB_EXPLAIN_SAMPLE_RATE, the fraction (0 to 1) of slow queries that
+// are explained. As the queries are run a second time to be analysed, this is always
+// disabled if ENV is "prod".
+func (d *Database) parseExplainRate() float64 {
+	val := utils.GetEnv("DB_EXPLAIN_SAMPLE_RATE", "")
+	if val == "" || utils.GetEnv("ENV", "") == "prod" {
+		return 0
+	}
+
+	rate, err := strconv.ParseFloat(val, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		d.logger.Warn("Invalid DB_EXPLAIN_SAMPLE_RATE \"" + val + "\"; not explaining slow queries")
+		return 0
+	}
+	return rate
+}
+
+// observe records a completed query: in the metrics, in the stats of the request it was
+// made for, and in the slow query log if it took longer than the threshold
+// (DB_SLOW_QUERY_THRESHOLD, or 500ms if not set; 0 disables it). Must be called before the
+// query's span ends, so that the plan (if any) can be attached to it.
+func (d *Database) observe(ctx context.Context, span trace.Span, e execution) {
+	duration := time.Since(e.start)
+	logSQL(e.spanName, e.operation, e.pool, e.start, e.err)
+
+	if scope := scopeFrom(ctx); scope != nil {
+		scope.queries.Add(1)
+		scope.duration.Add(int64(duration))
+	}
+
+	if d.slowThreshold <= 0 || duration < d.slowThreshold {
+		return
+	}
+
+	fields := []zap.Field{
+		zap.String("span", e.spanName),
+		zap.Duration("duration", duration),
+		zap.Int64("rows", e.rows),
+		zap.Strings("args", redactArgs(e.args)),
+		zap.String("pool", e.pool),
+		zap.String("query", e.query),
+	}
+	if e.err != nil {
+		fields = append(fields, zap.String("outcome", outcome(e.err)))
+	}
+
+	// Queries within a transaction are not explained, as a failure would abort the transaction
+	_, inTx := d.txFor(ctx)
+	if e.err == nil && !inTx && e.q != nil && readOnly(e.query) && d.explainRate > 0 && rand.Float64() < d.explainRate {
+		plan, err := d.explain(ctx, e.q, e.query, e.args)
+		if err != nil {
+			d.logger.Warn("Unable to explain slow query " + e.spanName + ": " + err.Error())
+		} else {
+			fields = append(fields, zap.String("plan", plan))
+			span.SetAttributes(attribute.String("db.plan", plan))
+		}
+	}
+
+	d.logger.Warn("Slow query "+e.spanName, fields...)
+}
+
+// explain runs the query again using EXPLAIN ANALYZE, returning the plan as text. Only
+// read-only queries should be explained, as the query is actually executed.
+func (d *Database) explain(ctx context.Context, q querier, query string, args []interface{}) (string, error) {
+	ctx, cancel := d.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := q.Query(ctx, "EXPLAIN (ANALYZE, BUFFERS) "+query, args...)
+	if err != nil {
+		return "", err
+	}
+
+	lines, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// redactArgs describes query arguments by their type only, so that values (which may be
+// personal data or secrets) are not logged.
+func redactArgs(args []interface{}) []string {
+	redacted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == nil {
+			redacted[i] = fmt.Sprintf("$%d=NULL", i+1)
+		} else {
+			redacted[i] = fmt.Sprintf("$%d=%T", i+1, arg)
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+	"time"
+)
+
+func TestRedactArgs(t *testing.T) {
+	assert.Equal(t, []string{"$1=string", "$2=int", "$3=NULL"}, redactArgs([]interface{}{"secret", 42, nil}))
+	assert.Empty(t, redactArgs(nil))
+}
+
+func TestParseExplainRate(t *testing.T) {
+	d := &Database{logger: zap.NewNop()}
+
+	t.Setenv("ENV", "staging")
+	t.Setenv("DB_EXPLAIN_SAMPLE_RATE", "")
+	assert.Equal(t, 0.0, d.parseExplainRate())
+	t.Setenv("DB_EXPLAIN_SAMPLE_RATE", "0.25")
+	assert.Equal(t, 0.25, d.parseExplainRate())
+	t.Setenv("DB_EXPLAIN_SAMPLE_RATE", "2")
+	assert.Equal(t, 0.0, d.parseExplainRate())
+
+	t.Setenv("ENV", "prod")
+	t.Setenv("DB_EXPLAIN_SAMPLE_RATE", "0.25")
+	assert.Equal(t, 0.0, d.parseExplainRate())
+}
+
+func TestObserve(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	d := &Database{logger: zap.New(core), slowThreshold: time.Second}
+	span := trace.SpanFromContext(context.Background())
+	ctx := WithRequestScope(context.Background())
+
+	fast := execution{spanName: "fastQuery", operation: opQuery, pool: primaryPool, start: time.Now()}
+	d.observe(ctx, span, fast)
+	assert.Equal(t, 0, logs.Len())
+
+	slow := execution{spanName: "slowQuery", operation: opExec, pool: primaryPool, query: "UPDATE items SET name = $1",
+		args: []interface{}{"secret"}, start: time.Now().Add(-2 * time.Second), rows: 3}
+	d.observe(ctx, span, slow)
+	if assert.Equal(t, 1, logs.Len()) {
+		fields := logs.All()[0].ContextMap()
+		assert.Equal(t, "slowQuery", fields["span"])
+		assert.Equal(t, int64(3), fields["rows"])
+		assert.NotContains(t, fields["args"], "secret")
+	}
+
+	queries, total := QueryStats(ctx)
+	assert.Equal(t, int64(2), queries)
+	assert.GreaterOrEqual(t, total, 2*time.Second)
+
+	queries, _ = QueryStats(context.Background())
+	assert.Equal(t, int64(0), queries)
+}
+
+func TestObserveBatchNotExplained(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	d := &Database{logger: zap.New(core), slowThreshold: time.Second, explainRate: 1}
+	span := trace.SpanFromContext(context.Background())
+
+	batch := execution{spanName: "slowBatch", operation: opBatch, pool: primaryPool,
+		query: "SELECT * FROM items WHERE id = $1;\nSELECT * FROM tags", start: time.Now().Add(-2 * time.Second)}
+	d.observe(context.Background(), span, batch)
+	if assert.Equal(t, 1, logs.Len()) {
+		assert.Equal(t, "Slow query slowBatch", logs.All()[0].Message)
+		assert.NotContains(t, logs.All()[0].ContextMap(), "plan")
+	}
+}
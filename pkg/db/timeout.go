@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/kaphos/webapp/pkg/utils"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 2 * time.Second
+
+type timeoutKey struct{}
+
+// WithTimeout returns a context that overrides the Database's default timeout for queries
+// made with it (e.g. for reports that legitimately take longer). A timeout of 0 or less
+// disables the client-side timeout, though statement_timeout (if set) still applies.
+func WithTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, timeout)
+}
+
+// SetTimeout sets the default timeout for each query (DB_TIMEOUT, or 2s if not set).
+func (d *Database) SetTimeout(timeout time.Duration) { d.timeout = timeout }
+
+// withTimeout applies the timeout for a single query to ctx: the override set using
+// WithTimeout, if any, or the Database's default otherwise.
+func (d *Database) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := d.timeout
+	if override, ok := ctx.Value(timeoutKey{}).(time.Duration); ok {
+		timeout = override
+	}
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// parseTimeout reads a duration (e.g. "500ms") from an environment variable.
+func (d *Database) parseTimeout(key string, fallback time.Duration) time.Duration {
+	val := utils.GetEnv(key, "")
+	if val == "" {
+		return fallback
+	}
+
+	timeout, err := time.ParseDuration(val)
+	if err != nil {
+		d.logger.Warn("Invalid " + key + " \"" + val + "\"; using " + fallback.String())
+		return fallback
+	}
+	return timeout
+}
+
+// sessionSettings returns the settings applied to each new connection. The timeouts are
+// passed to Postgres as-is (e.g. "30s"), and are left at the server's defaults if not set.
+func sessionSettings(appName string) map[string]string {
+	settings := map[string]string{"application_name": appName}
+	for setting, key := range map[string]string{
+		"statement_timeout":                   "DB_STATEMENT_TIMEOUT",
+		"lock_timeout":                        "DB_LOCK_TIMEOUT",
+		"idle_in_transaction_session_timeout": "DB_IDLE_IN_TX_TIMEOUT",
+	} {
+		if val := utils.GetEnv(key, ""); val != "" {
+			settings[setting] = val
+		}
+	}
+	return settings
+}
+
+// applySettings returns a pgxpool AfterConnect function, which applies settings to each
+// new connection, in a single round trip.
+func applySettings(settings map[string]string) func(ctx context.Context, conn *pgx.Conn) error {
+	query, args := settingsQuery(settings)
+	return func(ctx context.Context, conn *pgx.Conn) error {
+		_, err := conn.Exec(ctx, query, args...)
+		return err
+	}
+}
+
+func settingsQuery(settings map[string]string) (string, []interface{}) {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	calls := make([]string, len(names))
+	args := make([]interface{}, 0, 2*len(names))
+	for i, name := range names {
+		calls[i] = fmt.Sprintf("set_config($%d, $%d, false)", 2*i+1, 2*i+2)
+		args = append(args, name, settings[name])
+	}
+
+	return "SELECT " + strings.Join(calls, ", "), args
+}
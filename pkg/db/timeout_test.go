@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/kaphos/webapp/pkg/errchk"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	d := &Database{timeout: time.Second}
+
+	ctx, cancel := d.withTimeout(context.Background())
+	deadline, ok := ctx.Deadline()
+	cancel()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+
+	ctx, cancel = d.withTimeout(WithTimeout(context.Background(), time.Minute))
+	deadline, ok = ctx.Deadline()
+	cancel()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 100*time.Millisecond)
+
+	ctx, cancel = d.withTimeout(WithTimeout(context.Background(), 0))
+	_, ok = ctx.Deadline()
+	cancel()
+	assert.False(t, ok)
+}
+
+func TestParseTimeout(t *testing.T) {
+	d := &Database{logger: zap.NewNop()}
+
+	t.Setenv("DB_TIMEOUT", "")
+	assert.Equal(t, 2*time.Second, d.parseTimeout("DB_TIMEOUT", 2*time.Second))
+	t.Setenv("DB_TIMEOUT", "500ms")
+	assert.Equal(t, 500*time.Millisecond, d.parseTimeout("DB_TIMEOUT", 2*time.Second))
+	t.Setenv("DB_TIMEOUT", "soon")
+	assert.Equal(t, 2*time.Second, d.parseTimeout("DB_TIMEOUT", 2*time.Second))
+}
+
+func TestSessionSettings(t *testing.T) {
+	t.Setenv("DB_STATEMENT_TIMEOUT", "30s")
+	t.Setenv("DB_LOCK_TIMEOUT", "")
+	t.Setenv("DB_IDLE_IN_TX_TIMEOUT", "1min")
+
+	query, args := settingsQuery(sessionSettings("Test App"))
+	assert.Equal(t, "SELECT set_config($1, $2, false), set_config($3, $4, false), set_config($5, $6, false)", query)
+	assert.Equal(t, []interface{}{"application_name", "Test App", "idle_in_transaction_session_timeout", "1min",
+		"statement_timeout", "30s"}, args)
+}
+
+func TestConvertUserError(t *testing.T) {
+	assert.Nil(t, convertUserError(nil))
+	assert.Equal(t, errchk.ErrClientSide, convertUserError(&pgconn.PgError{Code: "23505"}))
+	assert.Equal(t, errchk.ErrTimeout, convertUserError(&pgconn.PgError{Code: "57014"}))
+	assert.Equal(t, errchk.ErrTimeout, convertUserError(fmt.Errorf("timeout: %w", context.DeadlineExceeded)))
+	assert.Equal(t, context.Canceled, convertUserError(context.Canceled))
+}
@@ -2,20 +2,185 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/kaphos/webapp/pkg/errchk"
+	"math/rand"
+	"time"
 )
 
-func (d *Database) NewTransaction(ctx context.Context, spanName string, f func(tx pgx.Tx) error) error {
+const (
+	defaultTxRetries = 3
+	txRetryBackoff   = 10 * time.Millisecond
+)
+
+// ErrRollback can be returned by the function passed to Transaction to roll the transaction
+// back without it being treated as an error; Transaction then returns nil.
+var ErrRollback = errors.New("transaction rolled back")
+
+type txKey struct{}
+
+// TxOption configures a transaction started using Database.Transaction.
+type TxOption func(*txConfig)
+
+type txConfig struct {
+	options pgx.TxOptions
+	retries int
+}
+
+// Isolation sets the transaction's isolation level (default: read committed).
+func Isolation(level pgx.TxIsoLevel) TxOption {
+	return func(cfg *txConfig) { cfg.options.IsoLevel = level }
+}
+
+// ReadOnly starts the transaction in read-only mode.
+func ReadOnly() TxOption {
+	return func(cfg *txConfig) { cfg.options.AccessMode = pgx.ReadOnly }
+}
+
+// Deferrable starts the transaction as deferrable. Only has an effect on serializable,
+// read-only transactions, which then wait until they can run without being cancelled.
+func Deferrable() TxOption {
+	return func(cfg *txConfig) { cfg.options.DeferrableMode = pgx.Deferrable }
+}
+
+// Retries sets how many times the transaction is retried (default 3) if it fails due to
+// a serialization failure or deadlock. 0 disables retries.
+func Retries(retries int) TxOption {
+	return func(cfg *txConfig) { cfg.retries = retries }
+}
+
+// TxFromContext returns the transaction carried by ctx, if it is running within Transaction.
+func TxFromContext(ctx context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(pgx.Tx)
+	return tx, ok
+}
+
+// UseTx routes every query made using the Database through tx (or back through the pool, if
+// tx is nil), with transactions becoming savepoints within it. Intended for isolating tests
+// (see the webapptest package), as tx cannot be used by multiple queries at once.
+func (d *Database) UseTx(tx pgx.Tx) {
+	if tx == nil {
+		d.pinned.Store(nil)
+		return
+	}
+	d.pinned.Store(&tx)
+}
+
+// txFor returns the transaction queries made using ctx should run in, if any: the one
+// carried by ctx, or the one set using UseTx otherwise.
+func (d *Database) txFor(ctx context.Context) (pgx.Tx, bool) {
+	if tx, ok := TxFromContext(ctx); ok {
+		return tx, true
+	}
+	if pinned := d.pinned.Load(); pinned != nil {
+		return *pinned, true
+	}
+	return nil, false
+}
+
+// Transaction runs f within a transaction, which is committed if f returns nil, and rolled
+// back if f returns an error or panics. The transaction is carried in the context passed to f,
+// so Query, QueryRow and Exec (and the helpers built on them) use it when called with that
+// context. f should therefore not use the transaction from multiple goroutines.
+//
+// If ctx already carries a transaction, a savepoint is used instead, so that only the work
+// done by f is rolled back on error; options are then ignored, as they can only be set on
+// the outer transaction. The outer transaction is retried with exponential backoff if it fails
+// due to a serialization failure or deadlock, so f may be called more than once, and should
+// not have side effects outside the database.
+func (d *Database) Transaction(ctx context.Context, spanName string, f func(ctx context.Context) error, opts ...TxOption) error {
+	cfg := txConfig{retries: defaultTxRetries}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	ctx, span := d.tracer.Start(ctx, spanName)
 	defer span.End()
 
-	tx, err := d.pool.BeginTx(ctx, pgx.TxOptions{})
-	if errchk.HaveError(err, "dbNewTx0") {
-		return err
+	if outer, ok := d.txFor(ctx); ok {
+		err := runTx(ctx, func(ctx context.Context) (pgx.Tx, error) { return outer.Begin(ctx) }, f)
+		return checkTxError(err, spanName)
 	}
-	err = convertUserError(f(tx))
-	errchk.Check(err, "dbNewTx1")
 
+	markWrite(ctx) // later reads in the request should see the transaction's writes
+	begin := func(ctx context.Context) (pgx.Tx, error) { return d.pool.BeginTx(ctx, cfg.options) }
+	return checkTxError(d.retryTx(ctx, spanName, cfg.retries, begin, f), spanName)
+}
+
+// retryTx runs f within a transaction (see runTx), retrying up to retries times with
+// exponential backoff if it fails due to a serialization failure or deadlock.
+func (d *Database) retryTx(ctx context.Context, spanName string, retries int, begin func(context.Context) (pgx.Tx, error), f func(context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, begin, f)
+		if attempt >= retries || !isRetryable(err) {
+			return err
+		}
+
+		d.logger.Debug(fmt.Sprintf("Retrying transaction \"%s\" (attempt %d): %s", spanName, attempt+1, err.Error()))
+		select {
+		case <-time.After(retryBackoff(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func checkTxError(err error, spanName string) error {
+	if errors.Is(err, ErrRollback) {
+		return nil
+	}
+
+	err = convertUserError(err)
+	errchk.Check(err, spanName)
 	return err
 }
+
+// NewTransaction runs f within a transaction, passing it the transaction directly.
+// See Transaction, which should be preferred.
+func (d *Database) NewTransaction(ctx context.Context, spanName string, f func(tx pgx.Tx) error) error {
+	return d.Transaction(ctx, spanName, func(ctx context.Context) error {
+		tx, _ := TxFromContext(ctx)
+		return f(tx)
+	})
+}
+
+// runTx begins a transaction (or savepoint), runs f with it in the context, and commits or
+// rolls back depending on the outcome.
+func runTx(ctx context.Context, begin func(context.Context) (pgx.Tx, error), f func(context.Context) error) (err error) {
+	tx, err := begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback(context.Background())
+			panic(p)
+		}
+	}()
+
+	if err = f(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		// Rolled back even if ctx is done, so that the connection is returned to the pool
+		_ = tx.Rollback(context.Background())
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// isRetryable returns true if the transaction failed due to a serialization
+// failure (40001) or deadlock (40P01), and so may succeed if retried.
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+// retryBackoff returns how long to wait before a retry, doubling with each attempt,
+// with jitter so that conflicting transactions do not retry in lockstep.
+func retryBackoff(attempt int) time.Duration {
+	backoff := txRetryBackoff << attempt
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+}
@@ -0,0 +1,201 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// fakeTx records what is done with a transaction (or savepoint) in a log shared with its
+// savepoints.
+type fakeTx struct {
+	pgx.Tx
+	name      string
+	log       *[]string
+	commitErr error
+}
+
+func newFakeTx(name string) *fakeTx {
+	return &fakeTx{name: name, log: new([]string)}
+}
+
+func (tx *fakeTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	savepoint := &fakeTx{name: tx.name + "/sp", log: tx.log}
+	*tx.log = append(*tx.log, "begin "+savepoint.name)
+	return savepoint, nil
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	*tx.log = append(*tx.log, "commit "+tx.name)
+	return tx.commitErr
+}
+
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	*tx.log = append(*tx.log, "rollback "+tx.name)
+	return nil
+}
+
+func testTxDB() *Database {
+	return &Database{logger: zap.NewNop(), tracer: trace.NewNoopTracerProvider().Tracer("")}
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, isRetryable(&pgconn.PgError{Code: "40001"}))
+	assert.True(t, isRetryable(fmt.Errorf("commit: %w", &pgconn.PgError{Code: "40P01"})))
+	assert.False(t, isRetryable(&pgconn.PgError{Code: "23505"}))
+	assert.False(t, isRetryable(errors.New("40001")))
+	assert.False(t, isRetryable(nil))
+}
+
+func TestRetryBackoff(t *testing.T) {
+	for attempt := 0; attempt < 4; attempt++ {
+		backoff := retryBackoff(attempt)
+		base := txRetryBackoff << attempt
+		assert.GreaterOrEqual(t, backoff, base/2)
+		assert.Less(t, backoff, base+base/2)
+	}
+	assert.Greater(t, retryBackoff(5), 100*time.Millisecond)
+}
+
+func TestTxFromContext(t *testing.T) {
+	_, ok := TxFromContext(context.Background())
+	assert.False(t, ok)
+}
+
+func TestUseTx(t *testing.T) {
+	d := testTxDB()
+	tx := newFakeTx("pinned")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			d.UseTx(tx)
+			d.UseTx(nil)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if got, ok := d.txFor(context.Background()); ok {
+			assert.Equal(t, tx, got)
+		}
+	}
+	<-done
+
+	_, ok := d.txFor(context.Background())
+	assert.False(t, ok)
+	d.UseTx(tx)
+	got, ok := d.txFor(context.Background())
+	assert.True(t, ok)
+	assert.Equal(t, tx, got)
+}
+
+func TestRunTx(t *testing.T) {
+	tx := newFakeTx("tx")
+	begin := func(ctx context.Context) (pgx.Tx, error) { return tx, nil }
+
+	err := runTx(context.Background(), begin, func(ctx context.Context) error {
+		carried, ok := TxFromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, tx, carried)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	failure := errors.New("insert failed")
+	assert.Equal(t, failure, runTx(context.Background(), begin, func(ctx context.Context) error { return failure }))
+
+	assert.PanicsWithValue(t, "oops", func() {
+		_ = runTx(context.Background(), begin, func(ctx context.Context) error { panic("oops") })
+	})
+
+	assert.Equal(t, []string{"commit tx", "rollback tx", "rollback tx"}, *tx.log)
+
+	beginErr := errors.New("connection refused")
+	called := false
+	err = runTx(context.Background(), func(ctx context.Context) (pgx.Tx, error) { return nil, beginErr },
+		func(ctx context.Context) error { called = true; return nil })
+	assert.Equal(t, beginErr, err)
+	assert.False(t, called)
+}
+
+func TestTransactionSavepoints(t *testing.T) {
+	d := testTxDB()
+	tx := newFakeTx("tx")
+	d.UseTx(tx)
+
+	err := d.Transaction(context.Background(), "outer", func(ctx context.Context) error {
+		inner := d.Transaction(ctx, "inner", func(ctx context.Context) error {
+			return &pgconn.PgError{Code: "23505"}
+		})
+		assert.NotNil(t, inner) // only the inner savepoint is rolled back
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = d.Transaction(context.Background(), "rolledBack", func(ctx context.Context) error { return ErrRollback })
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{
+		"begin tx/sp", "begin tx/sp/sp", "rollback tx/sp/sp", "commit tx/sp",
+		"begin tx/sp", "rollback tx/sp",
+	}, *tx.log)
+}
+
+func TestRetryTx(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		retries  int
+		errs     []error // returned by f on each attempt; nil once exhausted
+		commit   error   // returned by Commit on the first attempt
+		attempts int
+		err      error
+	}{
+		{"serialization failure", 3, []error{&pgconn.PgError{Code: "40001"}, &pgconn.PgError{Code: "40P01"}}, nil, 3, nil},
+		{"failure on commit", 3, nil, &pgconn.PgError{Code: "40001"}, 2, nil},
+		{"retries exhausted", 1, []error{&pgconn.PgError{Code: "40001"}, &pgconn.PgError{Code: "40001"}}, nil, 2, &pgconn.PgError{Code: "40001"}},
+		{"not retryable", 3, []error{&pgconn.PgError{Code: "23505"}}, nil, 1, &pgconn.PgError{Code: "23505"}},
+		{"retries disabled", 0, []error{&pgconn.PgError{Code: "40001"}}, nil, 1, &pgconn.PgError{Code: "40001"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			begin := func(ctx context.Context) (pgx.Tx, error) {
+				tx := newFakeTx("tx")
+				if attempts == 0 {
+					tx.commitErr = test.commit
+				}
+				return tx, nil
+			}
+
+			err := testTxDB().retryTx(context.Background(), test.name, test.retries, begin, func(ctx context.Context) error {
+				attempts++
+				if attempts <= len(test.errs) {
+					return test.errs[attempts-1]
+				}
+				return nil
+			})
+			assert.Equal(t, test.err, err)
+			assert.Equal(t, test.attempts, attempts)
+		})
+	}
+}
+
+func TestRetryTxCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	begin := func(ctx context.Context) (pgx.Tx, error) { return newFakeTx("tx"), nil }
+
+	err := testTxDB().retryTx(ctx, "cancelled", 3, begin, func(ctx context.Context) error {
+		attempts++
+		cancel()
+		return &pgconn.PgError{Code: "40001"}
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 1, attempts)
+}
@@ -3,55 +3,100 @@ package db
 import (
 	"context"
 	"github.com/jackc/pgx/v5"
-	"github.com/kaphos/webapp/internal/telemetry"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/kaphos/webapp/pkg/errchk"
 	"time"
 )
 
-const timeout = time.Second * 2
+// querier is implemented by both the pool and transactions.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error)
+}
 
 // Query performs a database query, returning a list of rows.
 // If only a single row is required, QueryRow should be used instead.
 // to standardise with the other 2 functions. Any errors encountered
 // internally are automatically handled using the errorhandling package.
+// Read-only queries may be sent to a replica (see UsePrimary).
+// The returned function must be called once done with the rows,
+// and closes them if they are still open.
 func (d *Database) Query(spanName string, parentCtx context.Context, query string, args ...interface{}) (pgx.Rows, func(), error) {
 	start := time.Now()
-	ctx, span := d.tracer.Start(parentCtx, spanName)
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	rows, err := d.pool.Query(ctx, query, args...)
-	err = convertUserError(err)
+	spanCtx, span := d.tracer.Start(parentCtx, spanName)
+	ctx, cancel := d.withTimeout(spanCtx)
+	q, pool, r := d.route(ctx, query)
+	rows, queryErr := q.Query(ctx, query, args...)
+	if r != nil {
+		d.checkReplicaError(r, queryErr)
+	}
+	err := convertUserError(queryErr)
 	errchk.Check(err, spanName)
 
 	endFn := func() {
-		span.End()
+		var count int64
+		if queryErr == nil {
+			rows.Close()
+			queryErr = rows.Err() // also records errors encountered while reading rows
+			count = rows.CommandTag().RowsAffected()
+		}
 		cancel()
-		telemetry.PromLogSQL("Query", time.Since(start).Seconds())
+		d.observe(spanCtx, span, execution{spanName, opQuery, pool, q, query, args, start, count, queryErr})
+		span.End()
 	}
 
 	return rows, endFn, err
 }
 
+// DB is implemented by Database, and is what repos use to access the database, so that they
+// can be tested against a fake (see the dbtest package).
+type DB interface {
+	Query(spanName string, ctx context.Context, query string, args ...interface{}) (pgx.Rows, func(), error)
+	QueryRow(spanName string, ctx context.Context, query string, args ...interface{}) QueryRowResult
+	Exec(spanName string, ctx context.Context, query string, args ...interface{}) error
+	NewTransaction(ctx context.Context, spanName string, f func(tx pgx.Tx) error) error
+}
+
+var _ DB = &Database{}
+
 type QueryRowResult struct {
 	spanName string
 	row      pgx.Row
-	end      func()
+	end      func(err error)
+}
+
+// NewQueryRowResult wraps a row, so that it is scanned in the same way as a row returned by
+// Database.QueryRow. For implementations of DB other than Database (e.g. fakes).
+func NewQueryRowResult(spanName string, row pgx.Row) QueryRowResult {
+	return QueryRowResult{spanName: spanName, row: row}
 }
 
 // QueryRow performs a database query and returns a single row.
 // Should be preferred over Query if only a single row is needed.
 // Should be called directly with Scan. Any errors encountered
 // internally are automatically handled using the errorhandling package.
-func (d *Database) QueryRow(spanName string, ctx context.Context, query string, args ...interface{}) QueryRowResult {
+func (d *Database) QueryRow(spanName string, parentCtx context.Context, query string, args ...interface{}) QueryRowResult {
 	start := time.Now()
-	ctx, span := d.tracer.Start(ctx, spanName)
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+	spanCtx, span := d.tracer.Start(parentCtx, spanName)
+	ctx, cancel := d.withTimeout(spanCtx)
 
-	row := d.pool.QueryRow(ctx, query, args...)
+	q, pool, r := d.route(ctx, query)
+	row := q.QueryRow(ctx, query, args...)
 
-	endFn := func() {
-		span.End()
+	endFn := func(err error) {
+		var count int64
+		if err == nil {
+			count = 1
+		}
+		if r != nil {
+			d.checkReplicaError(r, err)
+		}
 		cancel()
-		telemetry.PromLogSQL("Query", time.Since(start).Seconds())
+		d.observe(spanCtx, span, execution{spanName, opQueryRow, pool, q, query, args, start, count, err})
+		span.End()
 	}
 
 	return QueryRowResult{spanName, row, endFn}
@@ -62,8 +107,11 @@ func (d *Database) QueryRow(spanName string, ctx context.Context, query string,
 // so that "[]interface{}{}" does not need to be passed into the
 // function call.
 func (r QueryRowResult) Scan(dest ...interface{}) error {
-	defer r.end()
-	err := convertUserError(r.row.Scan(dest...))
+	scanErr := r.row.Scan(dest...)
+	if r.end != nil {
+		r.end(scanErr)
+	}
+	err := convertUserError(scanErr)
 	errchk.Check(err, r.spanName)
 	return err
 }
@@ -73,20 +121,22 @@ func (r QueryRowResult) Scan(dest ...interface{}) error {
 // an update or delete operation). The preferred function if a response
 // from the database is not required. Any errors encountered
 // internally are automatically handled using the errorhandling package.
-func (d *Database) Exec(spanName string, ctx context.Context, query string, args ...interface{}) error {
+// Always runs on the primary (or within the transaction carried by ctx).
+func (d *Database) Exec(spanName string, parentCtx context.Context, query string, args ...interface{}) error {
 	start := time.Now()
-	defer func() {
-		telemetry.PromLogSQL("Query", time.Since(start).Seconds())
-	}()
-
-	ctx, span := d.tracer.Start(ctx, spanName)
+	spanCtx, span := d.tracer.Start(parentCtx, spanName)
 	defer span.End()
 
-	ctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+	ctx, cancel := d.withTimeout(spanCtx)
+
+	q := d.primary(ctx)
+	markWrite(ctx)
+
+	tag, execErr := q.Exec(ctx, query, args...)
+	cancel()
+	d.observe(spanCtx, span, execution{spanName, opExec, primaryPool, q, query, args, start, tag.RowsAffected(), execErr})
 
-	_, err := d.pool.Exec(ctx, query, args...)
-	err = convertUserError(err)
+	err := convertUserError(execErr)
 	errchk.Check(err, spanName)
 	return err
 }
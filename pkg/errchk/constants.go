@@ -5,4 +5,5 @@ import "fmt"
 var (
 	ErrClientSide = fmt.Errorf("bad request")
 	ErrNoRows     = fmt.Errorf("no rows in result set")
+	ErrTimeout    = fmt.Errorf("query timed out") // handlers should generally respond with 504
 )
@@ -0,0 +1,361 @@
+// Package filter parses filtering and sorting parameters for list endpoints from the query
+// string (e.g. "?filter[price][gte]=10&sort=-created"), validates them against a whitelist
+// declared on the listed struct, and compiles them to parameterised SQL.
+//
+// Fields are made filterable by listing the allowed operators in a `filter` tag, and sortable
+// using a `sort:"true"` tag. Fields are referred to by their JSON name in the query string, and
+// by their column (see `db` tags) in SQL:
+//
+//	type Item struct {
+//		Name    string    `json:"name" filter:"eq,like"`
+//		Price   float64   `json:"price" filter:"eq,lt,lte,gt,gte" sort:"true"`
+//		Created time.Time `json:"created" db:"created_at" sort:"true"`
+//	}
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/kaphos/webapp/internal/structmap"
+	"github.com/kaphos/webapp/internal/swagger"
+	"github.com/kaphos/webapp/pkg/pagination"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Operator is a comparison that can be used to filter a field.
+type Operator string
+
+const (
+	Eq     Operator = "eq"
+	Ne     Operator = "ne"
+	Lt     Operator = "lt"
+	Lte    Operator = "lte"
+	Gt     Operator = "gt"
+	Gte    Operator = "gte"
+	In     Operator = "in"
+	Like   Operator = "like"
+	IsNull Operator = "is_null"
+)
+
+var sqlOperators = map[Operator]string{
+	Eq:   "=",
+	Ne:   "<>",
+	Lt:   "<",
+	Lte:  "<=",
+	Gt:   ">",
+	Gte:  ">=",
+	Like: "LIKE",
+}
+
+// MaxInValues limits the number of comma-separated values accepted by the "in" operator.
+const MaxInValues = 100
+
+var filterParamRegexp = regexp.MustCompile(`^filter\[([^\[\]]+)\](?:\[([^\[\]]+)\])?$`)
+
+type field struct {
+	structmap.Field
+	operators map[Operator]bool
+	sortable  bool
+	kind      structmap.Kind
+}
+
+// Spec is the whitelist of filterable and sortable fields of T. Should be created using For.
+type Spec[T any] struct {
+	fields map[string]field // keyed by JSON name
+}
+
+// For builds the filter specification for T from its `filter` and `sort` tags.
+// Panics if an unknown operator is listed, as this is a programming error.
+func For[T any]() Spec[T] {
+	spec := Spec[T]{fields: make(map[string]field)}
+
+	for _, f := range structmap.Of[T]() {
+		filterTag := f.Tag.Get("filter")
+		sortable := f.Tag.Get("sort") == "true"
+		if filterTag == "" && !sortable {
+			continue
+		}
+
+		specField := field{Field: f, operators: make(map[Operator]bool), sortable: sortable, kind: structmap.KindOf(f.Type)}
+		if filterTag != "" {
+			for _, op := range strings.Split(filterTag, ",") {
+				op := Operator(strings.TrimSpace(op))
+				if _, ok := sqlOperators[op]; !ok && op != In && op != IsNull {
+					panic("filter: unknown operator '" + string(op) + "' on field " + f.Name)
+				}
+				specField.operators[op] = true
+			}
+		}
+
+		spec.fields[f.JSONName] = specField
+	}
+
+	return spec
+}
+
+// Condition is a single validated filter.
+type Condition struct {
+	Column   string
+	Operator Operator
+	Values   []interface{} // a single value, except for In; empty for IsNull
+	Null     bool          // for IsNull: whether the column should be null
+}
+
+// SortField is a single validated sort key.
+type SortField struct {
+	Column     string
+	Descending bool
+}
+
+// Query is the parsed and validated set of filters and sort keys for a request.
+type Query struct {
+	Conditions []Condition
+	Sort       []SortField
+}
+
+// Bind parses the filters and sort keys from the request's query string. If they are invalid,
+// responds with 400 and returns false.
+func (s Spec[T]) Bind(c *gin.Context) (Query, bool) {
+	q, err := s.Parse(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return q, false
+	}
+	return q, true
+}
+
+// Parse parses the filters ("filter[field][op]=value", where "[op]" defaults to "[eq]") and
+// sort keys ("sort=field,-other" for descending) from the given query string, validating
+// them against the spec. Other parameters are ignored.
+func (s Spec[T]) Parse(values url.Values) (Query, error) {
+	q := Query{Conditions: make([]Condition, 0), Sort: make([]SortField, 0)}
+
+	// Sorted, so that the generated SQL is deterministic
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		matches := filterParamRegexp.FindStringSubmatch(key)
+		if matches == nil {
+			continue
+		}
+
+		name, op := matches[1], Operator(matches[2])
+		if op == "" {
+			op = Eq
+		}
+
+		f, ok := s.fields[name]
+		if !ok || !f.operators[op] {
+			return q, fmt.Errorf("filtering by %s (%s) is not allowed", name, op)
+		}
+
+		for _, raw := range values[key] {
+			cond, err := f.condition(op, raw)
+			if err != nil {
+				return q, fmt.Errorf("invalid value for filter[%s][%s]: %w", name, op, err)
+			}
+			q.Conditions = append(q.Conditions, cond)
+		}
+	}
+
+	if sortParam := values.Get("sort"); sortParam != "" {
+		for _, key := range strings.Split(sortParam, ",") {
+			descending := strings.HasPrefix(key, "-")
+			name := strings.TrimPrefix(key, "-")
+
+			f, ok := s.fields[name]
+			if !ok || !f.sortable {
+				return q, fmt.Errorf("sorting by %s is not allowed", name)
+			}
+			q.Sort = append(q.Sort, SortField{Column: f.Column, Descending: descending})
+		}
+	}
+
+	return q, nil
+}
+
+func (f field) condition(op Operator, raw string) (Condition, error) {
+	cond := Condition{Column: f.Column, Operator: op}
+
+	switch op {
+	case IsNull:
+		switch raw {
+		case "true", "":
+			cond.Null = true
+		case "false":
+			cond.Null = false
+		default:
+			return cond, errors.New("must be true or false")
+		}
+	case In:
+		parts := strings.Split(raw, ",")
+		if len(parts) > MaxInValues {
+			return cond, fmt.Errorf("at most %d values are allowed", MaxInValues)
+		}
+		for _, part := range parts {
+			val, err := f.kind.Parse(part)
+			if err != nil {
+				return cond, err
+			}
+			cond.Values = append(cond.Values, val)
+		}
+	case Like:
+		cond.Values = []interface{}{raw}
+	default:
+		val, err := f.kind.Parse(raw)
+		if err != nil {
+			return cond, err
+		}
+		cond.Values = []interface{}{val}
+	}
+
+	return cond, nil
+}
+
+// Where compiles the conditions into a SQL fragment (without the "WHERE" keyword), joined by
+// AND, along with its arguments. Placeholders are numbered after the first argOffset arguments.
+// Returns an empty string if there are no conditions.
+func (q Query) Where(argOffset int) (string, []interface{}) {
+	clauses := make([]string, 0, len(q.Conditions))
+	args := make([]interface{}, 0, len(q.Conditions))
+
+	for _, cond := range q.Conditions {
+		column := pgx.Identifier{cond.Column}.Sanitize()
+
+		switch cond.Operator {
+		case IsNull:
+			if cond.Null {
+				clauses = append(clauses, column+" IS NULL")
+			} else {
+				clauses = append(clauses, column+" IS NOT NULL")
+			}
+		case In:
+			placeholders := make([]string, len(cond.Values))
+			for i, val := range cond.Values {
+				args = append(args, val)
+				placeholders[i] = fmt.Sprintf("$%d", argOffset+len(args))
+			}
+			clauses = append(clauses, column+" IN ("+strings.Join(placeholders, ", ")+")")
+		default:
+			args = append(args, cond.Values[0])
+			clauses = append(clauses, fmt.Sprintf("%s %s $%d", column, sqlOperators[cond.Operator], argOffset+len(args)))
+		}
+	}
+
+	return strings.Join(clauses, " AND "), args
+}
+
+// OrderBy compiles the sort keys into a SQL fragment (without the "ORDER BY" keywords).
+// Returns an empty string if there are no sort keys.
+func (q Query) OrderBy() string {
+	keys := make([]string, len(q.Sort))
+	for i, key := range q.Sort {
+		keys[i] = pgx.Identifier{key.Column}.Sanitize()
+		if key.Descending {
+			keys[i] += " DESC"
+		} else {
+			keys[i] += " ASC"
+		}
+	}
+	return strings.Join(keys, ", ")
+}
+
+// Apply wraps query, filtering and sorting its results, and returns it along with the
+// updated args. The filtered and sorted columns must be among those returned by the query.
+func (q Query) Apply(query string, args ...interface{}) (string, []interface{}) {
+	where, whereArgs := q.Where(len(args))
+	orderBy := q.OrderBy()
+	if where == "" && orderBy == "" {
+		return query, args
+	}
+
+	query = "SELECT * FROM (" + query + ") AS filtered"
+	if where != "" {
+		query += " WHERE " + where
+	}
+	if orderBy != "" {
+		query += " ORDER BY " + orderBy
+	}
+
+	return query, append(args, whereArgs...)
+}
+
+// Paginate returns the Paginator to use for the query, along with the query to Apply. Keyset
+// pagination (see db.Paginate) orders the list by its keys, so in keyset mode the sort keys
+// are moved into a copy of p, followed by p's keys (e.g. the primary key) as a tiebreaker,
+// so that the list stays uniquely ordered. Offset pagination keeps the order given by Apply.
+func (q Query) Paginate(p pagination.Paginator) (Query, pagination.Paginator) {
+	if p.Mode != pagination.KeysetMode || len(q.Sort) == 0 {
+		return q, p
+	}
+
+	sorted := p
+	sorted.Keys = make([]string, 0, len(q.Sort)+len(p.Keys))
+	sorted.KeysDescending = make([]bool, 0, len(q.Sort)+len(p.Keys))
+	seen := make(map[string]bool, len(q.Sort))
+	for _, key := range q.Sort {
+		if !seen[key.Column] {
+			sorted.Keys = append(sorted.Keys, key.Column)
+			sorted.KeysDescending = append(sorted.KeysDescending, key.Descending)
+			seen[key.Column] = true
+		}
+	}
+	for i, key := range p.Keys {
+		if !seen[key] {
+			sorted.Keys = append(sorted.Keys, key)
+			sorted.KeysDescending = append(sorted.KeysDescending, p.KeyDescending(i))
+			seen[key] = true
+		}
+	}
+
+	q.Sort = nil
+	return q, sorted
+}
+
+// SwaggerParams documents the accepted filter and sort parameters, so that they can be
+// added to a handler using AddParamsFrom.
+func (s Spec[T]) SwaggerParams() map[string]swagger.SimpleParam {
+	params := make(map[string]swagger.SimpleParam)
+	sortable := make([]string, 0)
+
+	for name, f := range s.fields {
+		for op := range f.operators {
+			param := swagger.SimpleParam{Type: f.kind.SwaggerType(), Description: "Filter by " + name + " (" + string(op) + ")"}
+			switch op {
+			case In:
+				param.Type = "string"
+				param.Description += "; comma-separated list of values"
+			case IsNull:
+				param.Type = "boolean"
+			case Like:
+				param.Type = "string"
+				param.Description += "; use % as a wildcard"
+			}
+			params["filter["+name+"]["+string(op)+"]"] = param
+		}
+
+		if f.sortable {
+			sortable = append(sortable, name)
+		}
+	}
+
+	if len(sortable) > 0 {
+		sort.Strings(sortable)
+		params["sort"] = swagger.SimpleParam{
+			Type:        "string",
+			Description: "Comma-separated fields to sort by, prefixed with - for descending order. One of: " + strings.Join(sortable, ", "),
+		}
+	}
+
+	return params
+}
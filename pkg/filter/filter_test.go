@@ -0,0 +1,109 @@
+package filter
+
+import (
+	"github.com/gofrs/uuid/v5"
+	"github.com/kaphos/webapp/pkg/pagination"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v4"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type item struct {
+	ID      uuid.UUID  `json:"id" filter:"eq,in"`
+	Name    string     `json:"name" filter:"eq,like" sort:"true"`
+	Price   null.Float `json:"price" filter:"lt,gte,is_null" sort:"true"`
+	Count   int        `json:"count" filter:"ne"`
+	Created time.Time  `json:"created" db:"created_at" filter:"gte" sort:"true"`
+	Secret  string     `json:"secret"`
+}
+
+func parse(t *testing.T, query string) (Query, error) {
+	values, err := url.ParseQuery(query)
+	assert.Nil(t, err)
+	return For[item]().Parse(values)
+}
+
+func TestParse(t *testing.T) {
+	q, err := parse(t, "filter[price][gte]=10&filter[name]=abc&filter[created][gte]=2023-05-21&sort=-created,name&limit=5")
+	assert.Nil(t, err)
+	assert.Equal(t, []Condition{
+		{Column: "created_at", Operator: Gte, Values: []interface{}{time.Date(2023, 5, 21, 0, 0, 0, 0, time.UTC)}},
+		{Column: "name", Operator: Eq, Values: []interface{}{"abc"}},
+		{Column: "price", Operator: Gte, Values: []interface{}{10.0}},
+	}, q.Conditions)
+	assert.Equal(t, []SortField{{Column: "created_at", Descending: true}, {Column: "name"}}, q.Sort)
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, query := range []string{
+		"filter[secret]=abc",          // not filterable
+		"filter[price][eq]=10",        // operator not allowed
+		"filter[price][gte]=ten",      // wrong type
+		"filter[count][ne]=1.5",       // wrong type
+		"filter[id][in]=1,2",          // not UUIDs
+		"filter[price][is_null]=okay", // not a boolean
+		"sort=count",                  // not sortable
+	} {
+		_, err := parse(t, query)
+		assert.NotNil(t, err, query)
+	}
+}
+
+func TestApply(t *testing.T) {
+	q, err := parse(t, "filter[name][like]=a%25&filter[price][is_null]=false&filter[id][in]="+
+		"3fa85f64-5717-4562-b3fc-2c963f66afa6,3fa85f64-5717-4562-b3fc-2c963f66afa7&sort=price")
+	assert.Nil(t, err)
+
+	query, args := q.Apply("SELECT * FROM items WHERE owner = $1", "me")
+	assert.Equal(t, `SELECT * FROM (SELECT * FROM items WHERE owner = $1) AS filtered `+
+		`WHERE "id" IN ($2, $3) AND "name" LIKE $4 AND "price" IS NOT NULL ORDER BY "price" ASC`, query)
+	assert.Equal(t, []interface{}{
+		"me",
+		uuid.Must(uuid.FromString("3fa85f64-5717-4562-b3fc-2c963f66afa6")),
+		uuid.Must(uuid.FromString("3fa85f64-5717-4562-b3fc-2c963f66afa7")),
+		"a%",
+	}, args)
+
+	query, args = Query{}.Apply("SELECT * FROM items")
+	assert.Equal(t, "SELECT * FROM items", query)
+	assert.Empty(t, args)
+}
+
+func TestPaginate(t *testing.T) {
+	q, err := parse(t, "filter[name]=abc&sort=-created,name,-created")
+	assert.Nil(t, err)
+
+	keyset := pagination.NewKeyset(false, "id")
+	filtered, paginator := q.Paginate(keyset)
+	assert.Equal(t, []string{"created_at", "name", "id"}, paginator.Keys)
+	assert.Equal(t, []bool{true, false, false}, paginator.KeysDescending)
+	assert.Empty(t, filtered.Sort)
+	assert.Equal(t, q.Conditions, filtered.Conditions)
+	assert.Equal(t, []string{"id"}, keyset.Keys) // not modified
+
+	q, err = parse(t, "sort=-name")
+	assert.Nil(t, err)
+	_, paginator = q.Paginate(pagination.NewKeyset(true, "name", "id"))
+	assert.Equal(t, []string{"name", "id"}, paginator.Keys)
+	assert.Equal(t, []bool{true, true}, paginator.KeysDescending)
+
+	offset := pagination.NewOffset()
+	filtered, paginator = q.Paginate(offset)
+	assert.Equal(t, q, filtered)
+	assert.Equal(t, offset, paginator)
+
+	_, paginator = Query{}.Paginate(keyset)
+	assert.Equal(t, keyset, paginator)
+}
+
+func TestSwaggerParams(t *testing.T) {
+	params := For[item]().SwaggerParams()
+	assert.Equal(t, "number", params["filter[price][gte]"].Type)
+	assert.Equal(t, "boolean", params["filter[price][is_null]"].Type)
+	assert.Equal(t, "string", params["filter[id][in]"].Type)
+	assert.Contains(t, params["sort"].Description, "created, name, price")
+	_, found := params["filter[secret][eq]"]
+	assert.False(t, found)
+}
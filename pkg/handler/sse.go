@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/internal/httpbase"
+	"github.com/kaphos/webapp/internal/telemetry"
+	"github.com/kaphos/webapp/pkg/middleware"
+	"go/types"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultKeepAlive = 15 * time.Second
+
+// Event is a single Server-Sent Event. Only Data is required; the remaining
+// fields are written to the stream only if they are set.
+type Event[E any] struct {
+	ID    string        // used by the client as Last-Event-ID when reconnecting
+	Name  string        // the "event" field; clients listen for "message" if this is empty
+	Data  E             // JSON-encoded into the "data" field
+	Retry time.Duration // reconnection delay hint for the client
+}
+
+// FuncSSE is the function called by an SSE handler. Events are pushed to the client
+// through the Emitter until the function returns, at which point the stream is closed.
+// As with FuncU, false should be returned (with a status code set) if the request
+// should be rejected before any events are sent.
+type FuncSSE[E any] func(*gin.Context, *Emitter[E]) bool
+
+// Emitter writes typed events to an open Server-Sent Events stream.
+// It is safe to use from multiple goroutines.
+type Emitter[E any] struct {
+	c           *gin.Context
+	ctx         context.Context
+	lastEventID string
+	mu          sync.Mutex
+	started     bool
+	err         error
+}
+
+// Context returns a context that is cancelled once the client disconnects or
+// the server begins shutting down. The handler function should return when it is done.
+func (e *Emitter[E]) Context() context.Context { return e.ctx }
+
+// Done is a shortcut for Context().Done().
+func (e *Emitter[E]) Done() <-chan struct{} { return e.ctx.Done() }
+
+// LastEventID returns the ID of the last event received by the client, as sent in the
+// Last-Event-ID header when it reconnects. Empty on the first connection.
+func (e *Emitter[E]) LastEventID() string { return e.lastEventID }
+
+// Send pushes a single unnamed event with the given data to the client.
+func (e *Emitter[E]) Send(data E) error {
+	return e.SendEvent(Event[E]{Data: data})
+}
+
+// SendEvent pushes an event to the client, returning an error if the payload could not be
+// encoded, or if the stream has already been closed (e.g. the client disconnected).
+func (e *Emitter[E]) SendEvent(event Event[E]) error {
+	payload, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	if event.ID != "" {
+		sb.WriteString("id: " + sanitiseField(event.ID) + "\n")
+	}
+	if event.Name != "" {
+		sb.WriteString("event: " + sanitiseField(event.Name) + "\n")
+	}
+	if event.Retry > 0 {
+		sb.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	for _, line := range strings.Split(string(payload), "\n") {
+		sb.WriteString("data: " + line + "\n")
+	}
+	sb.WriteString("\n")
+
+	if err := e.write(sb.String()); err != nil {
+		return err
+	}
+
+	telemetry.StreamMessages.WithLabelValues("sse", "out").Inc()
+	return nil
+}
+
+// comment writes an SSE comment line, which clients ignore. Used for keep-alives.
+func (e *Emitter[E]) comment(text string) error {
+	return e.write(": " + text + "\n\n")
+}
+
+func (e *Emitter[E]) write(data string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.err != nil {
+		return e.err
+	}
+	if err := e.ctx.Err(); err != nil {
+		return err
+	}
+
+	e.start()
+	if _, err := e.c.Writer.WriteString(data); err != nil {
+		e.err = err
+		return err
+	}
+	e.c.Writer.Flush()
+	return nil
+}
+
+// start writes the stream headers, if they have not yet been written.
+// Must be called with mu held.
+func (e *Emitter[E]) start() {
+	if e.started {
+		return
+	}
+
+	e.started = true
+	header := e.c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // disable response buffering by nginx
+	e.c.Status(http.StatusOK)
+	e.c.Writer.WriteHeaderNow()
+	e.c.Writer.Flush()
+
+	telemetry.StreamsActive.WithLabelValues("sse").Inc()
+}
+
+// sanitiseField strips newlines from an id/event field, as they would otherwise
+// terminate the field early.
+func sanitiseField(val string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(val)
+}
+
+// SSE represents a handler that streams Server-Sent Events to the client.
+// Should create a new instance using NewSSE instead of instantiating this struct.
+type SSE[E any] struct {
+	httpbase.HandlerBase[types.Nil]
+	handler   FuncSSE[E]
+	keepAlive time.Duration
+}
+
+var _ httpbase.HandlerBaseI = &SSE[types.Nil]{}
+
+// NewSSE creates a new Server-Sent Events handler, served over GET at relativePath.
+// fn is called once per connection, and can push events of type E until it returns.
+// Middleware can also optionally be added, and runs before the stream is opened.
+func NewSSE[E any](relativePath string, fn FuncSSE[E], middleware ...middleware.Middleware) SSE[E] {
+	h := SSE[E]{
+		handler:     fn,
+		keepAlive:   defaultKeepAlive,
+		HandlerBase: httpbase.NewHandlerBase[types.Nil](http.MethodGet, http.StatusOK, relativePath),
+	}
+
+	h.AddContentResponse(http.StatusOK, "Stream of events, each with a JSON-encoded payload", "text/event-stream", *new(E))
+	h.AddResponses(500)
+	h.SetMiddleware(middleware...)
+
+	return h
+}
+
+// SetKeepAlive sets how often a keep-alive comment is sent while the stream is idle,
+// to prevent proxies from closing the connection. Defaults to 15 seconds.
+func (f *SSE[E]) SetKeepAlive(interval time.Duration) { f.keepAlive = interval }
+
+// Handle is an implementation of gin.HandleFunc. It opens the event stream, keeps it alive
+// while f.handler runs, and closes it once the client disconnects, the server shuts down,
+// or f.handler returns. Used by Server internally to attach a Repo to it.
+func (f *SSE[E]) Handle(c *gin.Context) {
+	httpbase.SetLongLived(c, "sse")
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	emitter := &Emitter[E]{
+		c:           c,
+		ctx:         ctx,
+		lastEventID: c.GetHeader("Last-Event-ID"),
+	}
+	defer func() {
+		// Deferred so that the stream is no longer counted even if f.handler panics
+		emitter.mu.Lock()
+		defer emitter.mu.Unlock()
+		if emitter.started {
+			telemetry.StreamsActive.WithLabelValues("sse").Dec()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		f.keepStreamAlive(c, emitter, cancel)
+	}()
+
+	ok := f.handler(c, emitter)
+	cancel()
+	wg.Wait()
+
+	emitter.mu.Lock()
+	defer emitter.mu.Unlock()
+
+	if !emitter.started && !ok {
+		// Rejected before any events were sent; treat it like any other handler
+		if c.Writer.Status() < 300 {
+			c.Status(http.StatusTeapot) // catch-all; returned false but no status code was set in the function
+		}
+		return
+	}
+
+	emitter.start() // no-op if events were already sent
+}
+
+// keepStreamAlive periodically writes a comment to the stream until the emitter's context
+// is done, and cancels it if the server begins shutting down.
+func (f *SSE[E]) keepStreamAlive(c *gin.Context, emitter *Emitter[E], cancel context.CancelFunc) {
+	ticker := time.NewTicker(f.keepAlive)
+	defer ticker.Stop()
+
+	shutdown := httpbase.ShutdownContext(c)
+
+	for {
+		select {
+		case <-emitter.Done():
+			return
+		case <-shutdown.Done():
+			cancel() // let the handler drain and return
+			return
+		case <-ticker.C:
+			if err := emitter.comment("keep-alive"); err != nil {
+				cancel()
+				return
+			}
+		}
+	}
+}
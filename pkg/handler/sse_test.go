@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/internal/telemetry"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSSEStreamsActiveOnPanic(t *testing.T) {
+	active := telemetry.StreamsActive.WithLabelValues("sse")
+	before := testutil.ToFloat64(active)
+
+	h := NewSSE("/", func(c *gin.Context, e *Emitter[string]) bool {
+		assert.Nil(t, e.Send("first"))
+		assert.Equal(t, before+1, testutil.ToFloat64(active))
+		panic("handler failed")
+	})
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.GET("/", h.Handle)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, before, testutil.ToFloat64(active))
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/internal/httpbase"
+	"github.com/kaphos/webapp/internal/swagger"
+	"github.com/kaphos/webapp/pkg/db"
+	"github.com/kaphos/webapp/pkg/errchk"
+	"github.com/kaphos/webapp/pkg/middleware"
+	"net/http"
+)
+
+var errTxUnsupported = errors.New("WithTx requires the database passed to repos to be a *db.Database")
+
+// WithTx returns middleware that runs the rest of the chain (including the handler) within
+// a single database transaction, carried in the request's context. Database calls made using
+// c.Request.Context() therefore use the transaction. It is committed only if the handler
+// returns true with a 2xx status, and rolled back otherwise (including if the handler panics,
+// or if the client disconnects). The response is held back until the transaction commits,
+// and replaced with a 500 if it fails to. Can be added to handlers created using NewU or
+// NewP, or to a repo (applying to all of its handlers), but not to streaming handlers.
+//
+// As the response cannot be replayed, the transaction is not retried on serialization
+// failures, even if the Retries option is given. The transaction is begun on the database
+// passed to repos, which must be a *db.Database: requests are rejected with a 500 if another
+// implementation of db.DB (e.g. a fake) is given using webapp.WithDatabase, as the handler's
+// statements would not run within the transaction.
+func WithTx(opts ...db.TxOption) middleware.Middleware {
+	opts = append(opts, db.Retries(0))
+
+	return middleware.Middleware{
+		Handler: func(c *gin.Context) {
+			database, ok := httpbase.Database(c).(*db.Database)
+			if !ok {
+				if httpbase.Database(c) != nil {
+					errchk.Check(errTxUnsupported, "tx "+c.FullPath())
+				}
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+
+			original, request := c.Writer, c.Request
+			buffered := httpbase.NewBufferedWriter(original)
+
+			err := database.Transaction(request.Context(), "tx "+c.FullPath(), func(ctx context.Context) error {
+				c.Writer, c.Request = buffered, request.WithContext(ctx)
+				defer func() { c.Writer, c.Request = original, request }() // also restored on panic
+
+				c.Next()
+
+				status := original.Status()
+				if !httpbase.Result(c) || status < 200 || status >= 300 || ctx.Err() != nil {
+					return db.ErrRollback
+				}
+				return nil
+			}, opts...)
+
+			if err != nil {
+				// Failed to begin or commit; the handler's response is discarded
+				original.Header().Del("Content-Type")
+				if err == errchk.ErrTimeout {
+					c.AbortWithStatus(http.StatusGatewayTimeout)
+				} else {
+					c.AbortWithStatus(http.StatusInternalServerError)
+				}
+				return
+			}
+
+			_, _ = original.Write(buffered.Body())
+		},
+		FailStatusCode: http.StatusInternalServerError,
+		FailResponse:   swagger.Response{Description: "Internal server error"},
+	}
+}
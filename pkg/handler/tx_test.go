@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/internal/httpbase"
+	"github.com/kaphos/webapp/pkg/db/dbtest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithTxWithoutDatabase(t *testing.T) {
+	called := false
+	h := NewU(http.MethodPost, "/", func(c *gin.Context) bool {
+		called = true
+		return true
+	}, http.StatusCreated, nil, WithTx())
+
+	router := gin.New()
+	router.POST("/", append(*h.Middleware(), h.Handle)...)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.False(t, called)
+	_, documented := h.Responses()[http.StatusInternalServerError]
+	assert.True(t, documented)
+}
+
+func TestWithTxWithFakeDatabase(t *testing.T) {
+	called := false
+	h := NewU(http.MethodPost, "/", func(c *gin.Context) bool {
+		called = true
+		return true
+	}, http.StatusCreated, nil, WithTx())
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { httpbase.SetDatabase(c, dbtest.New()) })
+	router.POST("/", append(*h.Middleware(), h.Handle)...)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.False(t, called)
+}
@@ -43,7 +43,9 @@ var _ httpbase.HandlerBaseI = &P[types.Nil]{}
 // handling of status codes, depending on whether f.handlers was successful
 // or not. Used by Server internally to attach a Repo to it.
 func (f *U) Handle(c *gin.Context) {
-	if ok := f.handler(c); ok {
+	ok := f.handler(c)
+	httpbase.SetResult(c, ok)
+	if ok {
 		c.Status(f.SuccessCode())
 	} else if c.Writer.Status() < 300 {
 		c.Status(http.StatusTeapot) // catch-all; returned false but no status code was set in the function
@@ -61,7 +63,9 @@ func (f *P[T]) Handle(c *gin.Context) {
 		return
 	}
 
-	if ok := f.handler(c, obj); ok {
+	ok := f.handler(c, obj)
+	httpbase.SetResult(c, ok)
+	if ok {
 		c.Status(f.SuccessCode())
 	} else if c.Writer.Status() < 300 {
 		c.Status(http.StatusTeapot) // catch-all; returned false but no status code was set in the function
@@ -0,0 +1,329 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/kaphos/webapp/internal/httpbase"
+	"github.com/kaphos/webapp/internal/telemetry"
+	"github.com/kaphos/webapp/pkg/middleware"
+	"go/types"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPingInterval   = 30 * time.Second
+	defaultWriteTimeout   = 10 * time.Second
+	defaultMaxMessageSize = 64 * 1024
+)
+
+// ErrInvalidMessage is returned by Conn.Receive if the client sent a message that
+// could not be decoded into an Envelope. The connection remains usable.
+var ErrInvalidMessage = errors.New("invalid websocket message")
+
+// Envelope is the JSON message format used in both directions over a WebSocket
+// connection. Type is used to tell messages apart, and ID can optionally be used
+// to correlate requests with responses.
+type Envelope[T any] struct {
+	Type string `json:"type" binding:"required"`
+	ID   string `json:"id,omitempty"`
+	Data T      `json:"data"`
+}
+
+// FuncWS is the function called by a WebSocket handler once the connection has been
+// upgraded. The connection is closed once the function returns.
+type FuncWS[In, Out any] func(*gin.Context, *Conn[In, Out]) bool
+
+type received[In any] struct {
+	envelope Envelope[In]
+	err      error
+}
+
+// Conn is an open WebSocket connection, which receives messages of type In and sends
+// messages of type Out. Send is safe to call from multiple goroutines, but Receive
+// should only be called from one.
+type Conn[In, Out any] struct {
+	ws           *websocket.Conn
+	ctx          context.Context
+	cancel       context.CancelFunc
+	incoming     chan received[In]
+	writeMu      sync.Mutex
+	writeTimeout time.Duration
+	closeOnce    sync.Once
+}
+
+// Context returns a context that is cancelled once the connection is closed, either by
+// the client, by the server shutting down, or by a missed keepalive.
+func (conn *Conn[In, Out]) Context() context.Context { return conn.ctx }
+
+// Receive blocks until the next message from the client is received. Returns
+// ErrInvalidMessage if the message could not be decoded, and the context's
+// error once the connection has been closed.
+func (conn *Conn[In, Out]) Receive() (Envelope[In], error) {
+	select {
+	case msg, ok := <-conn.incoming:
+		if !ok {
+			return Envelope[In]{}, conn.ctx.Err()
+		}
+		return msg.envelope, msg.err
+	case <-conn.ctx.Done():
+		return Envelope[In]{}, conn.ctx.Err()
+	}
+}
+
+// Send writes a message of the given type to the client.
+func (conn *Conn[In, Out]) Send(msgType string, data Out) error {
+	return conn.SendEnvelope(Envelope[Out]{Type: msgType, Data: data})
+}
+
+// SendEnvelope writes a message to the client, returning an error if the
+// connection has already been closed.
+func (conn *Conn[In, Out]) SendEnvelope(envelope Envelope[Out]) error {
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+
+	if err := conn.ctx.Err(); err != nil {
+		return err
+	}
+
+	_ = conn.ws.SetWriteDeadline(time.Now().Add(conn.writeTimeout))
+	if err := conn.ws.WriteMessage(websocket.TextMessage, payload); err != nil {
+		conn.cancel()
+		return err
+	}
+
+	telemetry.StreamMessages.WithLabelValues("ws", "out").Inc()
+	return nil
+}
+
+// Close sends a close message with the given code (e.g. websocket.CloseNormalClosure)
+// to the client, and closes the connection. Subsequent calls have no effect.
+func (conn *Conn[In, Out]) Close(code int, reason string) {
+	conn.closeOnce.Do(func() {
+		conn.writeMu.Lock()
+		msg := websocket.FormatCloseMessage(code, reason)
+		_ = conn.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(conn.writeTimeout))
+		conn.writeMu.Unlock()
+
+		conn.cancel()
+		_ = conn.ws.Close()
+	})
+}
+
+// terminate closes the connection without notifying the client (e.g. if it is unreachable).
+func (conn *Conn[In, Out]) terminate() {
+	conn.closeOnce.Do(func() {
+		conn.cancel()
+		_ = conn.ws.Close()
+	})
+}
+
+func (conn *Conn[In, Out]) ping() error {
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+	return conn.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(conn.writeTimeout))
+}
+
+// readLoop decodes incoming messages until the connection is closed, cancelling
+// the connection's context when it is.
+func (conn *Conn[In, Out]) readLoop(pongTimeout time.Duration) {
+	defer close(conn.incoming)
+	defer conn.cancel()
+
+	_ = conn.ws.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.ws.SetPongHandler(func(string) error {
+		return conn.ws.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
+
+	for {
+		_, payload, err := conn.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = conn.ws.SetReadDeadline(time.Now().Add(pongTimeout))
+		telemetry.StreamMessages.WithLabelValues("ws", "in").Inc()
+
+		var msg received[In]
+		if err := json.Unmarshal(payload, &msg.envelope); err != nil || msg.envelope.Type == "" {
+			msg.err = ErrInvalidMessage
+		}
+
+		select {
+		case conn.incoming <- msg:
+		case <-conn.ctx.Done():
+			return
+		}
+	}
+}
+
+// WS represents a handler that upgrades the request to a WebSocket connection,
+// exchanging JSON envelopes with the client. Should create a new instance using
+// NewWS instead of instantiating this struct.
+type WS[In, Out any] struct {
+	httpbase.HandlerBase[types.Nil]
+	handler        FuncWS[In, Out]
+	allowedOrigins []string
+	maxConns       int64
+	activeConns    *int64
+	pingInterval   time.Duration
+	maxMessageSize int64
+}
+
+var _ httpbase.HandlerBaseI = &WS[types.Nil, types.Nil]{}
+
+// NewWS creates a new WebSocket handler, served over GET at relativePath. Middleware
+// (e.g. middleware.NewAuth) runs on the upgrade request, before the connection is
+// upgraded. fn is called once per connection.
+func NewWS[In, Out any](relativePath string, fn FuncWS[In, Out], middleware ...middleware.Middleware) WS[In, Out] {
+	h := WS[In, Out]{
+		handler:        fn,
+		activeConns:    new(int64),
+		pingInterval:   defaultPingInterval,
+		maxMessageSize: defaultMaxMessageSize,
+		HandlerBase:    httpbase.NewHandlerBase[types.Nil](http.MethodGet, http.StatusSwitchingProtocols, relativePath),
+	}
+
+	h.AddResponse(http.StatusSwitchingProtocols, "Upgraded to a WebSocket connection; messages are sent as JSON envelopes", Envelope[Out]{})
+	h.AddResponse(http.StatusBadRequest, "Not a valid WebSocket handshake", nil)
+	h.AddResponse(http.StatusForbidden, "Origin not allowed", nil)
+	h.AddResponses(500)
+	h.SetMiddleware(middleware...)
+
+	return h
+}
+
+// SetAllowedOrigins sets the list of origins (e.g. "https://example.com") that may open a
+// connection. "*" allows any origin. If not set, only same-origin requests are allowed.
+func (f *WS[In, Out]) SetAllowedOrigins(origins ...string) { f.allowedOrigins = origins }
+
+// SetMaxConnections limits the number of connections open at once on this handler.
+// Further requests are rejected with 503. Defaults to 0 (unlimited).
+func (f *WS[In, Out]) SetMaxConnections(max int) {
+	f.maxConns = int64(max)
+	if max > 0 {
+		f.AddResponse(http.StatusServiceUnavailable, "Too many open connections", nil)
+	}
+}
+
+// SetKeepAlive sets how often the server pings the client. Connections that do not respond
+// within twice this interval are closed. Defaults to 30 seconds.
+func (f *WS[In, Out]) SetKeepAlive(interval time.Duration) { f.pingInterval = interval }
+
+// SetMaxMessageSize sets the maximum size of a message received from the client, in bytes.
+// Connections sending larger messages are closed. Defaults to 64KiB.
+func (f *WS[In, Out]) SetMaxMessageSize(size int64) { f.maxMessageSize = size }
+
+func (f *WS[In, Out]) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true // not a browser
+	}
+
+	if len(f.allowedOrigins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+
+	for _, allowed := range f.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Handle is an implementation of gin.HandleFunc. It upgrades the connection, runs
+// f.handler, and closes the connection once the handler returns, the client disconnects,
+// or the server shuts down. Used by Server internally to attach a Repo to it.
+func (f *WS[In, Out]) Handle(c *gin.Context) {
+	if !f.checkOrigin(c.Request) { // checked first, so that rejected requests are not counted
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	if active := atomic.AddInt64(f.activeConns, 1); f.maxConns > 0 && active > f.maxConns {
+		atomic.AddInt64(f.activeConns, -1)
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	defer atomic.AddInt64(f.activeConns, -1)
+
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }} // checked above
+
+	httpbase.SetLongLived(c, "ws")
+	c.Status(http.StatusSwitchingProtocols) // so that the status is logged correctly after hijacking
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // upgrader has already responded with an error
+	}
+	ws.SetReadLimit(f.maxMessageSize)
+
+	telemetry.StreamsActive.WithLabelValues("ws").Inc()
+	defer telemetry.StreamsActive.WithLabelValues("ws").Dec()
+
+	// The request's context is not cancelled when a hijacked connection is closed,
+	// so the read loop is responsible for cancelling it instead.
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	conn := &Conn[In, Out]{
+		ws:           ws,
+		ctx:          ctx,
+		cancel:       cancel,
+		incoming:     make(chan received[In]),
+		writeTimeout: defaultWriteTimeout,
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		conn.readLoop(2 * f.pingInterval)
+	}()
+	go func() {
+		defer wg.Done()
+		f.keepConnAlive(c, conn)
+	}()
+
+	if ok := f.handler(c, conn); ok {
+		conn.Close(websocket.CloseNormalClosure, "")
+	} else {
+		conn.Close(websocket.CloseInternalServerErr, "")
+	}
+	wg.Wait()
+}
+
+// keepConnAlive pings the client until the connection is closed, and closes the
+// connection if the server begins shutting down.
+func (f *WS[In, Out]) keepConnAlive(c *gin.Context, conn *Conn[In, Out]) {
+	ticker := time.NewTicker(f.pingInterval)
+	defer ticker.Stop()
+
+	shutdown := httpbase.ShutdownContext(c)
+
+	for {
+		select {
+		case <-conn.ctx.Done():
+			return
+		case <-shutdown.Done():
+			conn.Close(websocket.CloseGoingAway, "server shutting down")
+			return
+		case <-ticker.C:
+			if err := conn.ping(); err != nil {
+				conn.terminate()
+				return
+			}
+		}
+	}
+}
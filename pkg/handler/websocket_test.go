@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go/types"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWSOriginCheckedFirst(t *testing.T) {
+	h := NewWS("/", func(*gin.Context, *Conn[types.Nil, types.Nil]) bool { return true })
+	h.SetAllowedOrigins("https://example.com")
+	h.SetMaxConnections(1)
+	*h.activeConns = 1 // at capacity
+
+	router := gin.New()
+	router.GET("/", h.Handle)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://attacker.example")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, int64(1), *h.activeConns)
+
+	w = httptest.NewRecorder()
+	req.Header.Set("Origin", "https://example.com")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, int64(1), *h.activeConns)
+}
@@ -9,7 +9,10 @@ import (
 type Middleware struct {
 	// Function to call to run middleware. The Repo struct will automatically Handle
 	// failures by calling Abort, and continue the function call by calling Next.
-	Fn             func(ctx *gin.Context) bool
+	Fn func(ctx *gin.Context) bool
+	// Alternatively, a Gin HandlerFunc can be set instead of Fn, for middleware that needs to
+	// run code after the rest of the chain (by calling Next itself). Fn is then ignored.
+	Handler        gin.HandlerFunc
 	FailStatusCode int              // Status code to return if middleware fails
 	FailResponse   swagger.Response // Swagger response if middleware fails
 	AuthGroups     []string
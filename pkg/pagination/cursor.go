@@ -0,0 +1,100 @@
+package pagination
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/kaphos/webapp/internal/log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidCursor is returned when a cursor could not be decoded, or its signature does
+// not match (i.e. it was not issued by this server, or was tampered with).
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+var secret []byte
+var secretOnce sync.Once
+
+// getSecret returns the key used to sign cursors, read from `PAGINATION_SECRET`.
+// If it is not set, a random key is generated, meaning that cursors are only valid
+// for this instance of the server.
+func getSecret() []byte {
+	secretOnce.Do(func() {
+		if val := os.Getenv("PAGINATION_SECRET"); val != "" {
+			secret = []byte(val)
+			return
+		}
+
+		log.Get("PAGE").Warn("PAGINATION_SECRET not set; cursors will not be valid across instances.")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic("unable to generate pagination secret: " + err.Error())
+		}
+	})
+	return secret
+}
+
+// Cursor identifies a position in a paginated list. In offset mode, it holds the offset of
+// the page; in keyset mode, it holds the key values of the row next to the page, and the
+// direction to read in.
+type Cursor struct {
+	Offset   int           `json:"o,omitempty"`
+	Values   []interface{} `json:"v,omitempty"`
+	Backward bool          `json:"b,omitempty"`
+}
+
+// Encode returns the cursor as an opaque, signed string.
+func (cur Cursor) Encode() string {
+	payload, _ := json.Marshal(cur) // only contains values that were originally JSON-decoded or scanned
+	mac := hmac.New(sha256.New, getSecret())
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DecodeCursor parses a string returned by Cursor.Encode, verifying its signature.
+func DecodeCursor(encoded string) (Cursor, error) {
+	var cur Cursor
+
+	payloadStr, sigStr, found := strings.Cut(encoded, ".")
+	if !found {
+		return cur, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(payloadStr)
+	if err != nil {
+		return cur, ErrInvalidCursor
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(sigStr)
+	if err != nil {
+		return cur, ErrInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, getSecret())
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return cur, ErrInvalidCursor
+	}
+
+	// Decode numbers as json.Number, so that they are passed to the database as-is
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&cur); err != nil || cur.Offset < 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	for i, val := range cur.Values {
+		if num, ok := val.(json.Number); ok {
+			cur.Values[i] = num.String() // sent as text, and parsed by the database according to the column type
+		}
+	}
+
+	return cur, nil
+}
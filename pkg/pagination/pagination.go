@@ -0,0 +1,188 @@
+// Package pagination provides helpers for paginating list endpoints, in either offset/limit
+// or keyset (cursor) mode. Pagination parameters are read from the query string using
+// Paginator.Bind, applied to a query using db.Paginate, and returned to the client in a
+// standard envelope (with RFC 8288 Link headers) using Respond.
+package pagination
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/internal/swagger"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Mode is the way in which a list is paginated.
+type Mode int
+
+const (
+	// OffsetMode skips a number of rows. Simple, but slow for deep pages, and
+	// rows may be skipped or repeated if the list changes between requests.
+	OffsetMode Mode = iota
+	// KeysetMode continues from the key of the last row seen. Stable and fast,
+	// but requires the list to be ordered by a unique set of keys.
+	KeysetMode
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Paginator describes how a list endpoint is paginated. Should be created using
+// NewOffset or NewKeyset.
+type Paginator struct {
+	Mode           Mode
+	Keys           []string // keyset mode: columns that uniquely order the list, e.g. {"created", "id"}
+	Descending     bool     // keyset mode: whether the list is ordered by Keys in descending order
+	KeysDescending []bool   // keyset mode: the direction of each of Keys, overriding Descending if set
+	DefaultLimit   int
+	MaxLimit       int
+}
+
+// NewOffset creates a Paginator in offset mode, with the default limits.
+func NewOffset() Paginator {
+	return Paginator{Mode: OffsetMode, DefaultLimit: DefaultLimit, MaxLimit: MaxLimit}
+}
+
+// NewKeyset creates a Paginator in keyset mode, with the default limits. keys are the columns
+// that the list is ordered by, which together must uniquely identify a row (e.g. {"created", "id"}).
+func NewKeyset(descending bool, keys ...string) Paginator {
+	return Paginator{Mode: KeysetMode, Keys: keys, Descending: descending, DefaultLimit: DefaultLimit, MaxLimit: MaxLimit}
+}
+
+// KeyDescending returns whether the list is ordered by the i-th key in descending order.
+func (p Paginator) KeyDescending(i int) bool {
+	if p.KeysDescending != nil {
+		return p.KeysDescending[i]
+	}
+	return p.Descending
+}
+
+// Page is a single page requested by the client.
+type Page struct {
+	Paginator
+	Limit  int
+	Cursor Cursor
+}
+
+// Bind reads the pagination parameters ("limit" and "cursor") from the request's query string.
+// If they are invalid, responds with 400 and returns false.
+func (p Paginator) Bind(c *gin.Context) (Page, bool) {
+	page := Page{Paginator: p, Limit: p.DefaultLimit}
+
+	if val := c.Query("limit"); val != "" {
+		limit, err := strconv.Atoi(val)
+		if err != nil || limit < 1 || limit > p.MaxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(p.MaxLimit)})
+			return page, false
+		}
+		page.Limit = limit
+	}
+
+	if val := c.Query("cursor"); val != "" {
+		cur, err := DecodeCursor(val)
+		if err != nil || (p.Mode == KeysetMode && cur.Values != nil && len(cur.Values) != len(p.Keys)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": ErrInvalidCursor.Error()})
+			return page, false
+		}
+		page.Cursor = cur
+	}
+
+	return page, true
+}
+
+// Response is the standard envelope for a paginated list. Next and Prev are cursors,
+// to be passed as the "cursor" query parameter, and are omitted if there is no such page.
+type Response[T any] struct {
+	Data []T    `json:"data"`
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// SwaggerParams documents the query parameters read by Paginator.Bind. Used to automatically
+// document these parameters for any handler returning a Response.
+func (r Response[T]) SwaggerParams() map[string]swagger.SimpleParam {
+	return map[string]swagger.SimpleParam{
+		"limit":  {Type: "integer", Description: "Maximum number of results to return"},
+		"cursor": {Type: "string", Description: "Cursor for the page to return, as given in \"next\" or \"prev\" of a previous response"},
+	}
+}
+
+// NewResponse builds a Response from the rows returned by a query paginated using db.Paginate
+// (which fetches one row more than the limit, to tell if there are further rows). In keyset mode,
+// key should return the values of the Paginator's Keys for a given row; it is unused in offset mode.
+func NewResponse[T any](page Page, rows []T, key func(T) []interface{}) Response[T] {
+	resp := Response[T]{Data: rows}
+	hasMore := len(rows) > page.Limit
+	if hasMore {
+		resp.Data = rows[:page.Limit]
+	}
+
+	if page.Mode == OffsetMode {
+		if hasMore {
+			resp.Next = Cursor{Offset: page.Cursor.Offset + page.Limit}.Encode()
+		}
+		if page.Cursor.Offset > 0 {
+			prev := page.Cursor.Offset - page.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			resp.Prev = Cursor{Offset: prev}.Encode()
+		}
+		return resp
+	}
+
+	if page.Cursor.Backward {
+		// Rows were fetched in reverse order; restore the original order
+		for i, j := 0, len(resp.Data)-1; i < j; i, j = i+1, j-1 {
+			resp.Data[i], resp.Data[j] = resp.Data[j], resp.Data[i]
+		}
+	}
+
+	if len(resp.Data) == 0 {
+		return resp
+	}
+
+	first, last := resp.Data[0], resp.Data[len(resp.Data)-1]
+	// When reading forwards, there is a previous page if we started from a cursor, and a next page
+	// if there were more rows. The opposite applies when reading backwards.
+	if (!page.Cursor.Backward && hasMore) || (page.Cursor.Backward && page.Cursor.Values != nil) {
+		resp.Next = Cursor{Values: key(last)}.Encode()
+	}
+	if (page.Cursor.Backward && hasMore) || (!page.Cursor.Backward && page.Cursor.Values != nil) {
+		resp.Prev = Cursor{Values: key(first), Backward: true}.Encode()
+	}
+
+	return resp
+}
+
+// Respond writes the page as a Response with the given status code, along with
+// RFC 8288 Link headers pointing to the next and previous pages.
+func Respond[T any](c *gin.Context, status int, page Page, rows []T, key func(T) []interface{}) {
+	resp := NewResponse(page, rows, key)
+
+	links := make([]string, 0, 2)
+	if resp.Next != "" {
+		links = append(links, "<"+pageURL(c, page, resp.Next)+">; rel=\"next\"")
+	}
+	if resp.Prev != "" {
+		links = append(links, "<"+pageURL(c, page, resp.Prev)+">; rel=\"prev\"")
+	}
+	if len(links) > 0 {
+		c.Header("Link", strings.Join(links, ", "))
+	}
+
+	c.JSON(status, resp)
+}
+
+// pageURL returns the current request's URL, with the cursor replaced.
+func pageURL(c *gin.Context, page Page, cursor string) string {
+	u := url.URL{Path: c.Request.URL.Path}
+	query := c.Request.URL.Query()
+	query.Set("cursor", cursor)
+	query.Set("limit", strconv.Itoa(page.Limit))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
@@ -0,0 +1,87 @@
+package pagination
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCursor(t *testing.T) {
+	encoded := Cursor{Values: []interface{}{"abc", 12345678901234}, Backward: true}.Encode()
+
+	cur, err := DecodeCursor(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, Cursor{Values: []interface{}{"abc", "12345678901234"}, Backward: true}, cur)
+
+	_, err = DecodeCursor(encoded[:len(encoded)-2] + "xx")
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	_, err = DecodeCursor("not-a-cursor")
+	assert.Equal(t, ErrInvalidCursor, err)
+}
+
+func bind(p Paginator, query string) (Page, bool, int) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/items?"+query, nil)
+	page, ok := p.Bind(c)
+	return page, ok, w.Code
+}
+
+func TestBind(t *testing.T) {
+	page, ok, _ := bind(NewOffset(), "")
+	assert.True(t, ok)
+	assert.Equal(t, DefaultLimit, page.Limit)
+
+	page, ok, _ = bind(NewOffset(), "limit=5&cursor="+Cursor{Offset: 10}.Encode())
+	assert.True(t, ok)
+	assert.Equal(t, 5, page.Limit)
+	assert.Equal(t, 10, page.Cursor.Offset)
+
+	for _, query := range []string{"limit=0", "limit=101", "limit=abc", "cursor=abc"} {
+		_, ok, code := bind(NewOffset(), query)
+		assert.False(t, ok, query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+
+	// Cursor with the wrong number of keys
+	_, ok, _ = bind(NewKeyset(false, "id"), "cursor="+Cursor{Values: []interface{}{1, 2}}.Encode())
+	assert.False(t, ok)
+}
+
+func TestNewResponseOffset(t *testing.T) {
+	page := Page{Paginator: NewOffset(), Limit: 2, Cursor: Cursor{Offset: 1}}
+	resp := NewResponse(page, []int{1, 2, 3}, nil)
+	assert.Equal(t, []int{1, 2}, resp.Data)
+
+	next, _ := DecodeCursor(resp.Next)
+	assert.Equal(t, 3, next.Offset)
+	prev, _ := DecodeCursor(resp.Prev)
+	assert.Equal(t, 0, prev.Offset)
+
+	resp = NewResponse(Page{Paginator: NewOffset(), Limit: 2}, []int{1}, nil)
+	assert.Empty(t, resp.Next)
+	assert.Empty(t, resp.Prev)
+}
+
+func TestNewResponseKeyset(t *testing.T) {
+	key := func(i int) []interface{} { return []interface{}{i} }
+
+	// First page, with more rows
+	resp := NewResponse(Page{Paginator: NewKeyset(false, "id"), Limit: 2}, []int{1, 2, 3}, key)
+	assert.Equal(t, []int{1, 2}, resp.Data)
+	assert.Empty(t, resp.Prev)
+	next, _ := DecodeCursor(resp.Next)
+	assert.Equal(t, Cursor{Values: []interface{}{"2"}}, next)
+
+	// Reading backwards from 5; rows are fetched in reverse
+	page := Page{Paginator: NewKeyset(false, "id"), Limit: 2, Cursor: Cursor{Values: []interface{}{5}, Backward: true}}
+	resp = NewResponse(page, []int{4, 3, 2}, key)
+	assert.Equal(t, []int{3, 4}, resp.Data)
+	next, _ = DecodeCursor(resp.Next)
+	assert.Equal(t, Cursor{Values: []interface{}{"4"}}, next)
+	prev, _ := DecodeCursor(resp.Prev)
+	assert.Equal(t, Cursor{Values: []interface{}{"3"}, Backward: true}, prev)
+}
@@ -0,0 +1,416 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/kaphos/webapp/internal/httpbase"
+	"github.com/kaphos/webapp/internal/structmap"
+	"github.com/kaphos/webapp/pkg/db"
+	"github.com/kaphos/webapp/pkg/errchk"
+	"github.com/kaphos/webapp/pkg/filter"
+	"github.com/kaphos/webapp/pkg/handler"
+	"github.com/kaphos/webapp/pkg/middleware"
+	"github.com/kaphos/webapp/pkg/pagination"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Operation is one of the operations provided by CRUD.
+type Operation string
+
+const (
+	List   Operation = "list"
+	Get    Operation = "get"
+	Create Operation = "create"
+	Update Operation = "update"
+	Delete Operation = "delete"
+)
+
+// Hooks are optional functions called around CRUD operations. Returning false from
+// Authorize or Before aborts the operation; a status code should be set if so (otherwise,
+// Authorize defaults to 403). entity is nil for List, holds the payload for Create and Update
+// (and can be modified), and holds only the primary key for Get and Delete. After is called
+// with the resulting entity once the operation succeeds (nil for List).
+type Hooks[T any] struct {
+	Authorize func(c *gin.Context, op Operation) bool
+	Before    func(c *gin.Context, op Operation, entity *T) bool
+	After     func(c *gin.Context, op Operation, entity *T)
+}
+
+// CRUD is a Repo providing list, get, create, update and delete handlers for T, backed by a
+// single table. Columns are mapped using T's `db` tags (falling back to the JSON name), where
+// the primary key is marked as `db:"id,pk"`, and columns that should never be written by
+// create/update (e.g. generated IDs or timestamps) are marked as `db:"created,readonly"`.
+// Listing supports pagination (keyset on the primary key, by default) and filtering
+// (see the filter package). Should be created using NewCRUD.
+type CRUD[T any] struct {
+	Repo[T]
+	table     string
+	fields    []structmap.Field
+	pk        structmap.Field
+	idParam   string
+	paginator pagination.Paginator
+	filters   filter.Spec[T]
+	hooks     Hooks[T]
+	handlers  map[Operation]httpbase.HandlerBaseI
+}
+
+// NewCRUD creates a CRUD repo for T at relativePath, backed by the given table. Middleware is
+// applied to all operations. Panics if T does not have exactly one primary key field, as this
+// is a programming error.
+func NewCRUD[T any](relativePath, table string, middleware ...middleware.Middleware) *CRUD[T] {
+	r := &CRUD[T]{
+		table:    table,
+		fields:   structmap.Of[T](),
+		idParam:  "id",
+		filters:  filter.For[T](),
+		handlers: make(map[Operation]httpbase.HandlerBaseI),
+	}
+	r.SetRelativePath(relativePath)
+	r.SetMiddleware(middleware...)
+
+	pks := 0
+	for _, f := range r.fields {
+		if f.HasOption("pk") {
+			r.pk = f
+			pks++
+		}
+	}
+	if pks != 1 {
+		panic(fmt.Sprintf("repo: %T must have exactly one `db:\",pk\"` field", *new(T)))
+	}
+
+	r.paginator = pagination.NewKeyset(false, r.pk.Column)
+
+	name := strings.TrimSuffix(relativePath, "s")
+	entity := *new(T)
+
+	list := handler.NewU(http.MethodGet, "/", r.list, http.StatusOK, pagination.Response[T]{})
+	list.SetSummary("Lists " + relativePath + ".")
+	list.AddParamsFrom(r.filters)
+	r.register(List, &list)
+
+	get := handler.NewU(http.MethodGet, "/:"+r.idParam, r.get, http.StatusOK, entity)
+	get.SetSummary("Retrieves a single " + name + ".")
+	r.register(Get, &get)
+
+	create := handler.NewP(http.MethodPost, "/", r.create, http.StatusCreated, entity)
+	create.SetSummary("Creates a new " + name + ".")
+	r.register(Create, &create)
+
+	update := handler.NewP(http.MethodPut, "/:"+r.idParam, r.update, http.StatusOK, entity)
+	update.SetSummary("Updates an existing " + name + ".")
+	r.register(Update, &update)
+
+	del := handler.NewU(http.MethodDelete, "/:"+r.idParam, r.delete, http.StatusNoContent, nil)
+	del.SetSummary("Deletes a " + name + ".")
+	r.register(Delete, &del)
+
+	for _, op := range []Operation{Get, Update, Delete} {
+		h := r.handlers[op]
+		h.AddParam(r.idParam, structmap.KindOf(r.pk.Type).SwaggerType(), "Primary key of the "+name)
+		h.AddResponse(http.StatusNotFound, "Not found", nil)
+	}
+	for _, op := range []Operation{Create, Update} {
+		r.handlers[op].AddResponses(400)
+	}
+	for _, h := range r.handlers {
+		h.AddResponses(504)
+	}
+
+	return r
+}
+
+func (r *CRUD[T]) register(op Operation, h httpbase.HandlerBaseI) {
+	r.handlers[op] = h
+	r.AddHandler(h)
+}
+
+// Handler returns the handler generated for an operation (e.g. to customise its
+// documentation), or nil if it was disabled.
+func (r *CRUD[T]) Handler(op Operation) httpbase.HandlerBaseI {
+	return r.handlers[op]
+}
+
+// Override replaces the handler generated for an operation with a custom one.
+func (r *CRUD[T]) Override(op Operation, h httpbase.HandlerBaseI) {
+	for i, existing := range r.Handlers {
+		if existing == r.handlers[op] {
+			r.Handlers[i] = h
+			r.handlers[op] = h
+			return
+		}
+	}
+	r.register(op, h)
+}
+
+// Disable removes the handlers for the given operations.
+func (r *CRUD[T]) Disable(ops ...Operation) {
+	for _, op := range ops {
+		for i, existing := range r.Handlers {
+			if existing == r.handlers[op] {
+				r.Handlers = append(r.Handlers[:i], r.Handlers[i+1:]...)
+				break
+			}
+		}
+		delete(r.handlers, op)
+	}
+}
+
+// SetHooks sets the functions called around each operation.
+func (r *CRUD[T]) SetHooks(hooks Hooks[T]) {
+	r.hooks = hooks
+	if hooks.Authorize != nil {
+		for _, h := range r.handlers {
+			h.AddResponse(http.StatusForbidden, "Forbidden", nil)
+		}
+	}
+}
+
+// SetPaginator sets how the list operation is paginated. Keys must be columns of the table.
+func (r *CRUD[T]) SetPaginator(paginator pagination.Paginator) {
+	r.paginator = paginator
+}
+
+// SetIDParam renames the path parameter holding the primary key (default "id"). Needed if
+// the repo has child repos (see AddSubRepo), which must be mounted under the same parameter,
+// or if this repo is itself a child, so as not to clash with its parent's parameter.
+func (r *CRUD[T]) SetIDParam(param string) {
+	for _, op := range []Operation{Get, Update, Delete} {
+		if h, ok := r.handlers[op]; ok {
+			doc := h.Params()[r.idParam]
+			h.RemoveParam(r.idParam)
+			h.AddParam(param, doc.Type, doc.Description)
+			h.SetRelativePath("/:" + param)
+		}
+	}
+	r.idParam = param
+}
+
+func (r *CRUD[T]) columns(writable bool) []structmap.Field {
+	fields := make([]structmap.Field, 0, len(r.fields))
+	for _, f := range r.fields {
+		if writable && f.HasOption("readonly") {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func columnList(fields []structmap.Field) string {
+	columns := make([]string, len(fields))
+	for i, f := range fields {
+		columns[i] = pgx.Identifier{f.Column}.Sanitize()
+	}
+	return strings.Join(columns, ", ")
+}
+
+func (r *CRUD[T]) tableName() string {
+	return pgx.Identifier(strings.Split(r.table, ".")).Sanitize()
+}
+
+// values returns the values of the given fields of entity.
+func values[T any](entity *T, fields []structmap.Field) []interface{} {
+	val := reflect.ValueOf(entity).Elem()
+	args := make([]interface{}, len(fields))
+	for i, f := range fields {
+		args[i] = val.FieldByIndex(f.Index).Interface()
+	}
+	return args
+}
+
+// fail responds to a failed database call with an appropriate status code.
+func fail(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, errchk.ErrNoRows):
+		c.AbortWithStatus(http.StatusNotFound)
+	case errors.Is(err, errchk.ErrClientSide):
+		c.AbortWithStatus(http.StatusBadRequest)
+	case errors.Is(err, errchk.ErrTimeout):
+		c.AbortWithStatus(http.StatusGatewayTimeout)
+	default:
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+	return false
+}
+
+// authorize runs the Authorize hook, if any.
+func (r *CRUD[T]) authorize(c *gin.Context, op Operation) bool {
+	if r.hooks.Authorize == nil || r.hooks.Authorize(c, op) {
+		return true
+	}
+	if c.Writer.Status() < 300 {
+		c.AbortWithStatus(http.StatusForbidden)
+	}
+	return false
+}
+
+func (r *CRUD[T]) before(c *gin.Context, op Operation, entity *T) bool {
+	return r.hooks.Before == nil || r.hooks.Before(c, op, entity)
+}
+
+func (r *CRUD[T]) after(c *gin.Context, op Operation, entity *T) {
+	if r.hooks.After != nil {
+		r.hooks.After(c, op, entity)
+	}
+}
+
+// bindID parses the primary key from the path, into an entity that holds only the key.
+// Responds with 404 if it is not valid for the key's type.
+func (r *CRUD[T]) bindID(c *gin.Context) (*T, interface{}, bool) {
+	id, err := structmap.KindOf(r.pk.Type).Parse(c.Param(r.idParam))
+	if err != nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	entity := new(T)
+	field := reflect.ValueOf(entity).Elem().FieldByIndex(r.pk.Index)
+	if idVal := reflect.ValueOf(id); idVal.CanConvert(field.Type()) {
+		field.Set(idVal.Convert(field.Type()))
+	}
+
+	return entity, id, true
+}
+
+func (r *CRUD[T]) list(c *gin.Context) bool {
+	if !r.authorize(c, List) || !r.before(c, List, nil) {
+		return false
+	}
+
+	filters, ok := r.filters.Bind(c)
+	if !ok {
+		return false
+	}
+	filters, paginator := filters.Paginate(r.paginator) // in keyset mode, sorting changes the keys
+	page, ok := paginator.Bind(c)
+	if !ok {
+		return false
+	}
+
+	query, args := filters.Apply("SELECT " + columnList(r.fields) + " FROM " + r.tableName())
+	query, args = db.Paginate(query, page, args...)
+
+	entities, err := db.QueryAll[T](r.DB, "list_"+r.table, c.Request.Context(), query, args...)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	r.after(c, List, nil)
+
+	byColumn := structmap.ByColumn(reflect.TypeOf(*new(T)))
+	keys := make([]structmap.Field, len(page.Keys))
+	for i, key := range page.Keys {
+		keys[i] = byColumn[key]
+	}
+	pagination.Respond(c, http.StatusOK, page, entities, func(entity T) []interface{} {
+		return values(&entity, keys)
+	})
+	return true
+}
+
+func (r *CRUD[T]) get(c *gin.Context) bool {
+	if !r.authorize(c, Get) {
+		return false
+	}
+
+	entity, id, ok := r.bindID(c)
+	if !ok || !r.before(c, Get, entity) {
+		return false
+	}
+
+	query := "SELECT " + columnList(r.fields) + " FROM " + r.tableName() +
+		" WHERE " + pgx.Identifier{r.pk.Column}.Sanitize() + " = $1"
+	result, err := db.QueryOne[T](r.DB, "get_"+r.table, c.Request.Context(), query, id)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	r.after(c, Get, &result)
+	c.JSON(http.StatusOK, result)
+	return true
+}
+
+func (r *CRUD[T]) create(c *gin.Context, entity T) bool {
+	if !r.authorize(c, Create) || !r.before(c, Create, &entity) {
+		return false
+	}
+
+	writable := r.columns(true)
+	placeholders := make([]string, len(writable))
+	for i := range writable {
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	query := "INSERT INTO " + r.tableName() + " (" + columnList(writable) + ") VALUES (" +
+		strings.Join(placeholders, ", ") + ") RETURNING " + columnList(r.fields)
+	result, err := db.QueryOne[T](r.DB, "create_"+r.table, c.Request.Context(), query, values(&entity, writable)...)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	r.after(c, Create, &result)
+	c.JSON(http.StatusCreated, result)
+	return true
+}
+
+func (r *CRUD[T]) update(c *gin.Context, entity T) bool {
+	if !r.authorize(c, Update) {
+		return false
+	}
+
+	_, id, ok := r.bindID(c)
+	if !ok || !r.before(c, Update, &entity) {
+		return false
+	}
+
+	writable := make([]structmap.Field, 0)
+	for _, f := range r.columns(true) {
+		if !f.HasOption("pk") {
+			writable = append(writable, f)
+		}
+	}
+
+	assignments := make([]string, len(writable))
+	for i, f := range writable {
+		assignments[i] = fmt.Sprintf("%s = $%d", pgx.Identifier{f.Column}.Sanitize(), i+1)
+	}
+
+	query := "UPDATE " + r.tableName() + " SET " + strings.Join(assignments, ", ") +
+		fmt.Sprintf(" WHERE %s = $%d", pgx.Identifier{r.pk.Column}.Sanitize(), len(writable)+1) +
+		" RETURNING " + columnList(r.fields)
+	args := append(values(&entity, writable), id)
+	result, err := db.QueryOne[T](r.DB, "update_"+r.table, c.Request.Context(), query, args...)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	r.after(c, Update, &result)
+	c.JSON(http.StatusOK, result)
+	return true
+}
+
+func (r *CRUD[T]) delete(c *gin.Context) bool {
+	if !r.authorize(c, Delete) {
+		return false
+	}
+
+	entity, id, ok := r.bindID(c)
+	if !ok || !r.before(c, Delete, entity) {
+		return false
+	}
+
+	query := "DELETE FROM " + r.tableName() + " WHERE " + pgx.Identifier{r.pk.Column}.Sanitize() +
+		" = $1 RETURNING " + columnList(r.fields)
+	result, err := db.QueryOne[T](r.DB, "delete_"+r.table, c.Request.Context(), query, id)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	r.after(c, Delete, &result)
+	return true
+}
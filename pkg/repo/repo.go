@@ -1,32 +1,49 @@
 package repo
 
 import (
+	"github.com/gin-gonic/gin"
 	"github.com/kaphos/webapp/internal/httpbase"
 	"github.com/kaphos/webapp/pkg/db"
 	"go/types"
+	"net/http"
 )
 
 // RepoI defines the expected functions that a Server expects any
 // attached repositories to have.
 type RepoI interface {
 	httpbase.I
-	Init(database *db.Database)            // initialises any connections/configurations
+	Init(database db.DB)                   // initialises any connections/configurations
 	GetHandlers() *[]httpbase.HandlerBaseI // retrieve handlers, for attaching to the server and documentation
+	SubRepos() []SubRepo                   // retrieve child repos, mounted under this repo
+	ParentResolver(param string) gin.HandlerFunc
 }
 
+// SubRepo is a child repo, mounted under a path parameter of its parent
+// (e.g. "/items/:itemId/comments").
+type SubRepo struct {
+	Param string
+	Repo  RepoI
+}
+
+// FuncResolve looks up the entity identified by id, as given in a path parameter.
+// Should return false (optionally setting a status code) if it could not be found.
+type FuncResolve[T any] func(c *gin.Context, id string) (T, bool)
+
 // Repo represents a collection of APIs around one entity.
 // Should implement RepoI.
 type Repo[T any] struct {
 	httpbase.HTTPBase
-	DB       *db.Database            // database object; initialised by the server
+	DB       db.DB                   // database object; initialised by the server
 	Handlers []httpbase.HandlerBaseI // list of handlers
+	subRepos []SubRepo
+	resolver FuncResolve[T]
 }
 
 var _ RepoI = &Repo[types.Nil]{}
 
 // Init is called internally by the server when the Repo is attached to the server,
 // to set up the database and tracer instance.
-func (r *Repo[T]) Init(database *db.Database) {
+func (r *Repo[T]) Init(database db.DB) {
 	r.DB = database
 }
 
@@ -39,3 +56,61 @@ func (r *Repo[T]) GetHandlers() *[]httpbase.HandlerBaseI {
 func (r *Repo[T]) AddHandler(h httpbase.HandlerBaseI) {
 	r.Handlers = append(r.Handlers, h)
 }
+
+// AddSubRepo mounts a child repo under a path parameter of this repo. For example, if this
+// repo is at "items", calling AddSubRepo("itemId", commentRepo) serves commentRepo's handlers
+// at "/items/:itemId/comments/...". The child inherits this repo's middleware and declared
+// responses. If this repo has a resolver (see SetResolver), the parent entity is looked up
+// before the child's handlers run, and can be retrieved using Parent.
+//
+// param should match the name of the path parameter used by this repo's own handlers
+// (if any), as Gin does not allow differently-named parameters at the same position.
+func (r *Repo[T]) AddSubRepo(param string, child RepoI) {
+	r.subRepos = append(r.subRepos, SubRepo{Param: param, Repo: child})
+}
+
+// SubRepos returns the list of child repos mounted under this repo.
+func (r *Repo[T]) SubRepos() []SubRepo { return r.subRepos }
+
+// SetResolver sets the function used to look up this repo's entity from a child repo's path
+// parameter. Requests to child repos return 404 if the entity cannot be found.
+func (r *Repo[T]) SetResolver(fn FuncResolve[T]) {
+	r.resolver = fn
+}
+
+// ParentResolver returns a handler that resolves this repo's entity from the given path
+// parameter and stores it for child repos to retrieve using Parent. Returns nil if no
+// resolver has been set. Used by the Server when attaching child repos.
+func (r *Repo[T]) ParentResolver(param string) gin.HandlerFunc {
+	if r.resolver == nil {
+		return nil
+	}
+
+	return func(c *gin.Context) {
+		entity, ok := r.resolver(c, c.Param(param))
+		if !ok {
+			if c.Writer.Status() < 300 {
+				c.Status(http.StatusNotFound)
+			}
+			c.Abort()
+			return
+		}
+
+		c.Set(parentKey(param), entity)
+		c.Next()
+	}
+}
+
+func parentKey(param string) string { return "kphs.parent." + param }
+
+// Parent returns the parent entity resolved from the given path parameter, for a handler
+// in a child repo. The parent repo must have a resolver set (see SetResolver).
+func Parent[T any](c *gin.Context, param string) (T, bool) {
+	val, ok := c.Get(parentKey(param))
+	if !ok {
+		return *new(T), false
+	}
+
+	entity, ok := val.(T)
+	return entity, ok
+}
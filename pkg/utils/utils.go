@@ -3,6 +3,7 @@ package utils
 import (
 	"math/rand"
 	"os"
+	"strings"
 )
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ 0123456789-_+")
@@ -26,3 +27,23 @@ func GetEnv(key, fallback string) string {
 	}
 	return value
 }
+
+// GetSecret is similar to GetEnv, but if key is not set, reads the value from the file named
+// by key + "_FILE" (e.g. DB_PASS_FILE), as used for Docker and Kubernetes secrets.
+// Surrounding whitespace (e.g. a trailing newline) is trimmed from the file's contents.
+func GetSecret(key, fallback string) (string, error) {
+	if value := os.Getenv(key); len(value) > 0 {
+		return value, nil
+	}
+
+	path := os.Getenv(key + "_FILE")
+	if len(path) == 0 {
+		return fallback, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -22,3 +24,29 @@ func TestRandInt(t *testing.T) {
 	assert.NotEmpty(t, int2)
 	assert.NotEqual(t, int1, int2)
 }
+
+func TestGetSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	assert.Nil(t, os.WriteFile(path, []byte("from-file\n"), 0600))
+
+	t.Setenv("TEST_SECRET", "")
+	t.Setenv("TEST_SECRET_FILE", "")
+	val, err := GetSecret("TEST_SECRET", "fallback")
+	assert.Nil(t, err)
+	assert.Equal(t, "fallback", val)
+
+	t.Setenv("TEST_SECRET_FILE", path)
+	val, err = GetSecret("TEST_SECRET", "fallback")
+	assert.Nil(t, err)
+	assert.Equal(t, "from-file", val)
+
+	t.Setenv("TEST_SECRET", "from-env")
+	val, err = GetSecret("TEST_SECRET", "fallback")
+	assert.Nil(t, err)
+	assert.Equal(t, "from-env", val)
+
+	t.Setenv("TEST_SECRET", "")
+	t.Setenv("TEST_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+	_, err = GetSecret("TEST_SECRET", "fallback")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,333 @@
+package webapptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/kaphos/webapp"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// maxDumpBody is the length beyond which bodies are truncated in failure messages.
+const maxDumpBody = 4096
+
+// JSON builds a JSON object, e.g. for use as a request body.
+type JSON map[string]interface{}
+
+// Client makes requests to a Server's router, without starting it:
+//
+//	var items []Item
+//	webapptest.Do(t, s).GET("/api/items/").WithAuth("token").
+//		ExpectStatus(http.StatusOK).
+//		DecodeJSON(&items)
+//
+// Failed expectations are reported using t.Errorf, along with a dump of the request and
+// response.
+type Client struct {
+	t       testing.TB
+	handler http.Handler
+	headers http.Header
+	cookies []*http.Cookie
+	auth    func(req *http.Request, token string)
+}
+
+// Do returns a client for making requests to s.
+func Do(t testing.TB, s *webapp.Server) *Client {
+	return &Client{
+		t:       t,
+		handler: s.Router,
+		headers: make(http.Header),
+		auth: func(req *http.Request, token string) {
+			req.Header.Set("Authorization", "Bearer "+token)
+		},
+	}
+}
+
+// WithHeader sets a header on every request made using the client.
+func (c *Client) WithHeader(key, value string) *Client {
+	c.headers.Set(key, value)
+	return c
+}
+
+// WithCookie sets a cookie on every request made using the client.
+func (c *Client) WithCookie(cookie *http.Cookie) *Client {
+	c.cookies = append(c.cookies, cookie)
+	return c
+}
+
+// UseAuth sets how Request.WithAuth authenticates requests (by default, using the token as
+// a bearer token in the Authorization header).
+func (c *Client) UseAuth(auth func(req *http.Request, token string)) *Client {
+	c.auth = auth
+	return c
+}
+
+// GET starts a GET request to path (which can include a query string).
+func (c *Client) GET(path string) *Request { return c.Request(http.MethodGet, path) }
+
+// POST starts a POST request to path.
+func (c *Client) POST(path string) *Request { return c.Request(http.MethodPost, path) }
+
+// PUT starts a PUT request to path.
+func (c *Client) PUT(path string) *Request { return c.Request(http.MethodPut, path) }
+
+// PATCH starts a PATCH request to path.
+func (c *Client) PATCH(path string) *Request { return c.Request(http.MethodPatch, path) }
+
+// DELETE starts a DELETE request to path.
+func (c *Client) DELETE(path string) *Request { return c.Request(http.MethodDelete, path) }
+
+// Request starts a request to path using any method. The request is sent once an
+// expectation is made on its response (or Send is called).
+func (c *Client) Request(method, path string) *Request {
+	req := httptest.NewRequest(method, path, nil)
+	for key, values := range c.headers {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	return &Request{client: c, req: req}
+}
+
+// Request is a request being built. Should be created using Client.
+type Request struct {
+	client *Client
+	req    *http.Request
+	body   []byte
+	resp   *Response
+}
+
+// WithHeader sets a header on the request.
+func (r *Request) WithHeader(key, value string) *Request {
+	r.req.Header.Set(key, value)
+	return r
+}
+
+// WithCookie adds a cookie to the request.
+func (r *Request) WithCookie(cookie *http.Cookie) *Request {
+	r.req.AddCookie(cookie)
+	return r
+}
+
+// WithAuth authenticates the request using token (see Client.UseAuth).
+func (r *Request) WithAuth(token string) *Request {
+	r.client.auth(r.req, token)
+	return r
+}
+
+// WithQuery adds a query parameter to the request.
+func (r *Request) WithQuery(key, value string) *Request {
+	query := r.req.URL.Query()
+	query.Add(key, value)
+	r.req.URL.RawQuery = query.Encode()
+	return r
+}
+
+// WithJSON sets the request body to val, encoded as JSON (see JSON for building objects).
+func (r *Request) WithJSON(val interface{}) *Request {
+	body, err := json.Marshal(val)
+	if err != nil {
+		r.client.t.Fatalf("webapptest: unable to encode request body: %s", err)
+	}
+	return r.WithBody("application/json", body)
+}
+
+// WithForm sets the request body to the URL-encoded form values.
+func (r *Request) WithForm(values url.Values) *Request {
+	return r.WithBody("application/x-www-form-urlencoded", []byte(values.Encode()))
+}
+
+// WithBody sets the request body, along with its content type.
+func (r *Request) WithBody(contentType string, body []byte) *Request {
+	r.body = body
+	r.req.Header.Set("Content-Type", contentType)
+	return r
+}
+
+// Send sends the request (once; later calls return the same response).
+func (r *Request) Send() *Response {
+	if r.resp != nil {
+		return r.resp
+	}
+
+	r.req.Body = io.NopCloser(bytes.NewReader(r.body))
+	r.req.ContentLength = int64(len(r.body))
+	recorder := httptest.NewRecorder()
+	r.client.handler.ServeHTTP(recorder, r.req)
+
+	r.resp = &Response{t: r.client.t, request: r, Recorder: recorder, body: recorder.Body.Bytes()}
+	return r.resp
+}
+
+// ExpectStatus sends the request, and checks the status code of its response.
+func (r *Request) ExpectStatus(code int) *Response {
+	r.client.t.Helper()
+	return r.Send().ExpectStatus(code)
+}
+
+// Response is the response to a Request.
+type Response struct {
+	t        testing.TB
+	request  *Request
+	Recorder *httptest.ResponseRecorder
+	body     []byte
+}
+
+// Code returns the response's status code.
+func (r *Response) Code() int { return r.Recorder.Code }
+
+// Body returns the response's body.
+func (r *Response) Body() string { return string(r.body) }
+
+// Header returns the response's headers.
+func (r *Response) Header() http.Header { return r.Recorder.Header() }
+
+// ExpectStatus checks the status code of the response.
+func (r *Response) ExpectStatus(code int) *Response {
+	r.t.Helper()
+	if r.Recorder.Code != code {
+		r.fail("expected status %d, got %d", code, r.Recorder.Code)
+	}
+	return r
+}
+
+// ExpectHeader checks the value of a response header.
+func (r *Response) ExpectHeader(key, value string) *Response {
+	r.t.Helper()
+	if actual := r.Recorder.Header().Get(key); actual != value {
+		r.fail("expected header %s to be %q, got %q", key, value, actual)
+	}
+	return r
+}
+
+// ExpectBody checks the response body, compared exactly.
+func (r *Response) ExpectBody(body string) *Response {
+	r.t.Helper()
+	if string(r.body) != body {
+		r.fail("expected body %q", body)
+	}
+	return r
+}
+
+// DecodeJSON decodes the response body, which must be JSON, into out.
+func (r *Response) DecodeJSON(out interface{}) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.body, out); err != nil {
+		r.fail("unable to decode response: %s", err)
+	}
+	return r
+}
+
+// ExpectJSONPath checks the value at a path within the JSON response body. Paths are made up
+// of object keys and array indexes separated by dots, e.g. "data.0.name" (or "" for the whole
+// body). The expected value is compared after being encoded as JSON, so e.g. ints match
+// numbers, and structs match objects.
+func (r *Response) ExpectJSONPath(path string, expected interface{}) *Response {
+	r.t.Helper()
+
+	var doc interface{}
+	if err := json.Unmarshal(r.body, &doc); err != nil {
+		r.fail("unable to decode response: %s", err)
+		return r
+	}
+
+	actual, err := lookup(doc, path)
+	if err != nil {
+		r.fail("%s", err)
+		return r
+	}
+
+	normalised, err := normaliseJSON(expected)
+	if err != nil {
+		r.fail("unable to encode expected value: %s", err)
+		return r
+	}
+	if !reflect.DeepEqual(actual, normalised) {
+		r.fail("expected %q to be %s, got %s", path, mustMarshal(normalised), mustMarshal(actual))
+	}
+	return r
+}
+
+// fail reports a failed expectation, along with a dump of the request and response.
+func (r *Response) fail(format string, args ...interface{}) {
+	r.t.Helper()
+	r.t.Errorf("%s\n\n%s", fmt.Sprintf(format, args...), r.dump())
+}
+
+func (r *Response) dump() string {
+	var out strings.Builder
+	out.WriteString("--- Request ---\n")
+	if dumped, err := httputil.DumpRequest(r.request.req, false); err == nil {
+		out.Write(bytes.TrimRight(dumped, "\r\n"))
+		out.WriteString("\n")
+	}
+	out.WriteString(truncate(r.request.body))
+
+	out.WriteString("\n--- Response ---\n")
+	out.WriteString(strconv.Itoa(r.Recorder.Code) + " " + http.StatusText(r.Recorder.Code) + "\n")
+	for key, values := range r.Recorder.Header() {
+		out.WriteString(key + ": " + strings.Join(values, ", ") + "\n")
+	}
+	out.WriteString(truncate(r.body))
+	return out.String()
+}
+
+func truncate(body []byte) string {
+	if len(body) > maxDumpBody {
+		return string(body[:maxDumpBody]) + fmt.Sprintf("... (%d bytes truncated)", len(body)-maxDumpBody)
+	}
+	return string(body)
+}
+
+// lookup returns the value at a dot-separated path within a decoded JSON document.
+func lookup(doc interface{}, path string) (interface{}, error) {
+	if path == "" {
+		return doc, nil
+	}
+
+	current := doc
+	for i, key := range strings.Split(path, ".") {
+		switch val := current.(type) {
+		case map[string]interface{}:
+			next, ok := val[key]
+			if !ok {
+				return nil, fmt.Errorf("%q not found in response", strings.Join(strings.Split(path, ".")[:i+1], "."))
+			}
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(val) {
+				return nil, fmt.Errorf("%q not found in response (array has %d elements)", strings.Join(strings.Split(path, ".")[:i+1], "."), len(val))
+			}
+			current = val[index]
+		default:
+			return nil, fmt.Errorf("%q not found in response (not an object or array)", strings.Join(strings.Split(path, ".")[:i+1], "."))
+		}
+	}
+	return current, nil
+}
+
+// normaliseJSON converts val to the form it would take if decoded from JSON.
+func normaliseJSON(val interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	err = json.Unmarshal(encoded, &decoded)
+	return decoded, err
+}
+
+func mustMarshal(val interface{}) string {
+	encoded, _ := json.Marshal(val)
+	return string(encoded)
+}
@@ -0,0 +1,131 @@
+package webapptest
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// recorder captures failed expectations, instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func testServer(t *testing.T) *webapp.Server {
+	s, err := webapp.NewServer("Test App", "v1", "testuser", "testpass", 1)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	s.Router.POST("/echo", func(c *gin.Context) {
+		var body map[string]interface{}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		cookie, _ := c.Cookie("session")
+		c.JSON(http.StatusCreated, gin.H{
+			"id":      "0b5e3a4e-4f0e-4a8e-9a8e-2f0e4a8e9a8e",
+			"created": "2024-01-02T03:04:05Z",
+			"count":   7,
+			"auth":    c.GetHeader("Authorization"),
+			"cookie":  cookie,
+			"query":   c.Query("q"),
+			"body":    body,
+			"items":   []gin.H{{"name": "first"}, {"name": "second"}},
+		})
+	})
+	return &s
+}
+
+func TestClient(t *testing.T) {
+	s := testServer(t)
+
+	var resp map[string]interface{}
+	Do(t, s).WithCookie(&http.Cookie{Name: "session", Value: "abc"}).
+		POST("/echo").WithAuth("token").WithQuery("q", "search").WithJSON(JSON{"name": "Tag"}).
+		ExpectStatus(http.StatusCreated).
+		ExpectHeader("Content-Type", "application/json; charset=utf-8").
+		ExpectJSONPath("auth", "Bearer token").
+		ExpectJSONPath("cookie", "abc").
+		ExpectJSONPath("query", "search").
+		ExpectJSONPath("body", JSON{"name": "Tag"}).
+		ExpectJSONPath("count", 7).
+		ExpectJSONPath("items.1.name", "second").
+		DecodeJSON(&resp)
+	assert.Equal(t, "Tag", resp["body"].(map[string]interface{})["name"])
+
+	Do(t, s).UseAuth(func(req *http.Request, token string) { req.Header.Set("auth", token) }).
+		POST("/echo").WithAuth("true").WithBody("application/json", []byte("{}")).
+		ExpectStatus(http.StatusCreated).
+		ExpectJSONPath("auth", "")
+}
+
+func TestClientFailures(t *testing.T) {
+	s := testServer(t)
+	rec := &recorder{TB: t}
+
+	Do(rec, s).POST("/echo").WithJSON(JSON{"name": "Tag"}).
+		ExpectStatus(http.StatusOK).
+		ExpectHeader("X-Missing", "value").
+		ExpectJSONPath("count", 8).
+		ExpectJSONPath("items.2.name", "third").
+		ExpectJSONPath("body.name.first", "T")
+
+	if assert.Len(t, rec.errors, 5) {
+		assert.Contains(t, rec.errors[0], "expected status 200, got 201")
+		assert.Contains(t, rec.errors[0], "--- Request ---\nPOST /echo HTTP/1.1")
+		assert.Contains(t, rec.errors[0], `{"name":"Tag"}`)
+		assert.Contains(t, rec.errors[0], "--- Response ---\n201 Created")
+		assert.Contains(t, rec.errors[2], `expected "count" to be 8, got 7`)
+		assert.Contains(t, rec.errors[3], `"items.2" not found in response (array has 2 elements)`)
+		assert.Contains(t, rec.errors[4], `"body.name.first" not found in response (not an object or array)`)
+	}
+}
+
+func TestExpectSnapshot(t *testing.T) {
+	s := testServer(t)
+	dir, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(t.TempDir()))
+	defer func() { _ = os.Chdir(dir) }()
+
+	rec := &recorder{TB: t}
+	Do(rec, s).POST("/echo").WithJSON(JSON{"name": "Tag"}).Send().ExpectSnapshot("echo", "count")
+	if assert.Len(t, rec.errors, 1) {
+		assert.Contains(t, rec.errors[0], "does not exist")
+	}
+
+	t.Setenv("UPDATE_SNAPSHOTS", "true")
+	Do(t, s).POST("/echo").WithJSON(JSON{"name": "Tag"}).Send().ExpectSnapshot("echo", "count")
+	t.Setenv("UPDATE_SNAPSHOTS", "")
+	snapshot, err := os.ReadFile(filepath.Join("testdata", "snapshots", "echo.golden"))
+	if assert.Nil(t, err) {
+		assert.Contains(t, string(snapshot), "201 Created\n\n{\n")
+		assert.Contains(t, string(snapshot), `"id": "<masked>"`)
+		assert.Contains(t, string(snapshot), `"created": "<masked>"`)
+		assert.Contains(t, string(snapshot), `"count": "<masked>"`)
+		assert.Contains(t, string(snapshot), `"name": "Tag"`)
+	}
+
+	rec = &recorder{TB: t}
+	Do(rec, s).POST("/echo").WithJSON(JSON{"name": "Tag"}).Send().ExpectSnapshot("echo", "count")
+	assert.Empty(t, rec.errors)
+
+	Do(rec, s).POST("/echo").WithJSON(JSON{"name": "Other"}).Send().ExpectSnapshot("echo", "count")
+	if assert.Len(t, rec.errors, 1) {
+		assert.Contains(t, rec.errors[0], "does not match snapshot")
+	}
+}
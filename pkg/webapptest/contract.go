@@ -0,0 +1,428 @@
+package webapptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kaphos/webapp"
+	"github.com/kaphos/webapp/internal/apidocs"
+	"github.com/kaphos/webapp/internal/swagger"
+	"math"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ContractOption configures Contract.
+type ContractOption func(*contract)
+
+type contract struct {
+	auth         func(req *http.Request, groups []string)
+	params       map[string]string
+	skip         map[string]bool
+	serverErrors bool
+}
+
+// WithAuthProvider authenticates each request sent by Contract, given the auth groups the
+// operation is documented to require (which may be empty, e.g. if the middleware checking
+// authentication does not declare any).
+func WithAuthProvider(auth func(req *http.Request, groups []string)) ContractOption {
+	return func(c *contract) { c.auth = auth }
+}
+
+// WithParam sets the value used for a path or (required) query parameter. Parameters without
+// a value are given an example based on their type (e.g. "1").
+func WithParam(name, value string) ContractOption {
+	return func(c *contract) { c.params[name] = value }
+}
+
+// SkipOperation skips an operation, given its method and documented path (including the
+// group's base path, e.g. "/api/items/{id}").
+func SkipOperation(method, path string) ContractOption {
+	return func(c *contract) { c.skip[strings.ToUpper(method)+" "+path] = true }
+}
+
+// AllowServerErrors lets Contract pass operations that respond with a documented 5xx status
+// code, which are otherwise treated as failures. Fuzz always fails on them.
+func AllowServerErrors() ContractOption {
+	return func(c *contract) { c.serverErrors = true }
+}
+
+// Contract tests every operation documented by the server's groups, giving each handler
+// baseline coverage without a hand-written test. For each operation, the documented example
+// request (built from the request body's schema) is sent through the router, and the test
+// fails if the response's status code is 5xx (unless AllowServerErrors is given) or is not
+// one of the operation's documented responses, or if its JSON body does not match the schema
+// documented for that status code. Operations that cannot succeed in the test environment
+// (e.g. as they need a database) can be skipped using SkipOperation.
+//
+// Each operation is run as a subtest named after its method and path.
+func Contract(t *testing.T, s *webapp.Server, opts ...ContractOption) {
+	t.Helper()
+	c := newContract(opts)
+
+	for _, op := range c.operations(s) {
+		op := op
+		t.Run(op.name, func(t *testing.T) {
+			if c.skip[op.name] {
+				t.Skip("skipped using SkipOperation")
+			}
+
+			var body []byte
+			if schema, ok := op.bodySchema(); ok {
+				body, _ = json.Marshal(example(schema))
+			}
+
+			c.check(c.send(t, s, op, body), op)
+		})
+	}
+}
+
+// check reports a failure if the response does not match the operation's documentation.
+func (c *contract) check(resp *Response, op operation) {
+	resp.t.Helper()
+	if resp.Code() >= http.StatusInternalServerError && !c.serverErrors {
+		resp.fail("%s responded with a server error", op.name)
+		return
+	}
+	declared, ok := checkStatus(resp, op)
+	if !ok || resp.Code() < http.StatusOK || resp.Code() == http.StatusNoContent {
+		return
+	}
+
+	content, ok := declared.Content["application/json"]
+	if !ok {
+		return
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(resp.body, &decoded); err != nil {
+		resp.fail("response is not valid JSON: %s", err)
+		return
+	}
+	if problems := validate(content.Schema, decoded, "body"); len(problems) > 0 {
+		resp.fail("response does not match the documented schema:\n  %s", strings.Join(problems, "\n  "))
+	}
+}
+
+func newContract(opts []ContractOption) *contract {
+	c := &contract{params: make(map[string]string), skip: make(map[string]bool)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// operation is a documented operation, with its parameters filled in.
+type operation struct {
+	name   string // method and documented path, e.g. "GET /api/items/{id}/"
+	method string
+	path   string
+	query  url.Values
+	docs   *swagger.Operation
+}
+
+// bodySchema returns the schema of the operation's JSON request body, if it has one.
+func (op operation) bodySchema() (swagger.Schema, bool) {
+	if op.docs.RequestBody == nil {
+		return swagger.Schema{}, false
+	}
+	content, ok := op.docs.RequestBody.Content["application/json"]
+	return content.Schema, ok
+}
+
+// operations returns every operation documented by the server's groups, sorted by path.
+func (c *contract) operations(s *webapp.Server) []operation {
+	groups := apidocs.Of(s)
+	basePaths := make([]string, 0, len(groups))
+	for basePath := range groups {
+		basePaths = append(basePaths, basePath)
+	}
+	sort.Strings(basePaths)
+
+	operations := make([]operation, 0)
+	for _, basePath := range basePaths {
+		docs := groups[basePath]
+		paths := make([]string, 0, len(docs.Paths))
+		for path := range docs.Paths {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			fullPath := strings.TrimSuffix(basePath, "/") + path
+			item := docs.Paths[path]
+			for _, op := range []struct {
+				method string
+				docs   *swagger.Operation
+			}{
+				{http.MethodGet, item.Get},
+				{http.MethodPost, item.Post},
+				{http.MethodPut, item.Put},
+				{http.MethodDelete, item.Delete},
+			} {
+				if op.docs != nil {
+					filled, query := c.fillParams(fullPath, item.Parameters)
+					operations = append(operations, operation{op.method + " " + fullPath, op.method, filled, query, op.docs})
+				}
+			}
+		}
+	}
+	return operations
+}
+
+// fillParams fills in the path's parameters, returning it along with any query parameters.
+func (c *contract) fillParams(path string, params []swagger.Parameter) (string, url.Values) {
+	query := url.Values{}
+	for _, param := range params {
+		value, ok := c.params[param.Name]
+		if !ok {
+			value = fmt.Sprint(example(param.Schema))
+		}
+
+		switch {
+		case param.In == "path":
+			path = strings.ReplaceAll(path, "{"+param.Name+"}", url.PathEscape(value))
+		case param.In == "query" && (param.Required || ok):
+			query.Set(param.Name, value)
+		}
+	}
+	return path, query
+}
+
+// send sends a request for the operation, with the given body (if not nil). Documented paths
+// always end with a slash, which the router redirects from if the handler's path does not, so
+// such redirects are followed. Panics in handlers are reported as failures.
+func (c *contract) send(t testing.TB, s *webapp.Server, op operation, body []byte) *Response {
+	t.Helper()
+
+	request := func(path string) (resp *Response) {
+		if len(op.query) > 0 {
+			path += "?" + op.query.Encode()
+		}
+
+		req := Do(t, s).Request(op.method, path)
+		if body != nil {
+			req.WithBody("application/json", body)
+		}
+		if c.auth != nil {
+			groups := make([]string, 0)
+			for _, requirement := range op.docs.Security {
+				for _, scopes := range requirement {
+					groups = append(groups, scopes...)
+				}
+			}
+			c.auth(req.req, groups)
+		}
+
+		defer func() {
+			if p := recover(); p != nil {
+				t.Fatalf("%s panicked: %v\n\n--- Request body ---\n%s\n\n%s", op.name, p, truncate(body), debug.Stack())
+			}
+		}()
+		return req.Send()
+	}
+
+	resp := request(op.path)
+	if _, documented := op.docs.Responses[resp.Code()]; !documented && isRedirect(resp.Code()) &&
+		resp.Header().Get("Location") == strings.TrimSuffix(op.path, "/") {
+		resp = request(strings.TrimSuffix(op.path, "/"))
+	}
+	return resp
+}
+
+// checkStatus reports a failure if the response's status code is not documented for the
+// operation, returning the documented response otherwise.
+func checkStatus(resp *Response, op operation) (swagger.Response, bool) {
+	resp.t.Helper()
+	declared, ok := op.docs.Responses[resp.Code()]
+	if !ok {
+		codes := make([]int, 0, len(op.docs.Responses))
+		for code := range op.docs.Responses {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		resp.fail("status %d is not documented (documented: %v)", resp.Code(), codes)
+	}
+	return declared, ok
+}
+
+func isRedirect(code int) bool {
+	return code == http.StatusMovedPermanently || code == http.StatusTemporaryRedirect || code == http.StatusPermanentRedirect
+}
+
+// example returns an example value for a schema, as documented (or generated, in the same
+// way as the documentation does for fields).
+func example(schema swagger.Schema) interface{} {
+	if schema.Example != nil {
+		return schema.Example
+	}
+
+	switch schema.Type {
+	case "object", "":
+		if schema.Properties == nil {
+			if schema.Type == "object" {
+				return map[string]interface{}{}
+			}
+			return 1 // undocumented (e.g. path parameters, or ints and strings as bodies)
+		}
+		obj := make(map[string]interface{}, len(schema.Properties))
+		for name, property := range schema.Properties {
+			obj[name] = example(*property)
+		}
+		return obj
+	case "array":
+		if schema.Items == nil {
+			return []interface{}{}
+		}
+		return []interface{}{example(*schema.Items)}
+	case "integer":
+		return 123
+	case "number":
+		return 12.3
+	case "boolean":
+		return true
+	case "string":
+		switch schema.Format {
+		case "email":
+			return "johndoe@email.com"
+		case "date":
+			return "2023-05-21"
+		case "date-time":
+			return "2023-05-21T17:32:28Z"
+		case "uuid":
+			return "3fa85f64-5717-4562-b3fc-2c963f66afa6"
+		}
+		return "string value"
+	default:
+		return nil
+	}
+}
+
+// validate returns the ways in which a decoded JSON value does not match a schema, if any.
+// Null is accepted for nullable values, arrays and maps (as Go encodes nil slices and maps
+// as null).
+func validate(schema swagger.Schema, val interface{}, path string) []string {
+	if val == nil {
+		if schema.Nullable || schema.Type == "array" || schema.AdditionalProperties != nil || schema.Type == "" {
+			return nil
+		}
+		return []string{path + ": expected " + schema.Type + ", got null"}
+	}
+
+	problems := make([]string, 0)
+	mismatch := func(expected string) []string {
+		return append(problems, fmt.Sprintf("%s: expected %s, got %s", path, expected, jsonType(val)))
+	}
+
+	switch schema.Type {
+	case "object", "":
+		if schema.Properties == nil && schema.AdditionalProperties == nil {
+			if schema.Type == "object" {
+				if _, ok := val.(map[string]interface{}); !ok {
+					return mismatch("object")
+				}
+			}
+			return nil // undocumented
+		}
+
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			return mismatch("object")
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, path+"."+name+": required, but missing")
+			}
+		}
+
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := schema.Properties[name]; ok {
+				problems = append(problems, validate(*property, obj[name], path+"."+name)...)
+			} else if schema.AdditionalProperties != nil {
+				problems = append(problems, validate(*schema.AdditionalProperties, obj[name], path+"."+name)...)
+			}
+		}
+	case "array":
+		arr, ok := val.([]interface{})
+		if !ok {
+			return mismatch("array")
+		}
+		if schema.Items != nil {
+			for i, item := range arr {
+				problems = append(problems, validate(*schema.Items, item, path+"."+strconv.Itoa(i))...)
+			}
+		}
+	case "integer":
+		if num, ok := val.(float64); !ok || num != math.Trunc(num) {
+			return mismatch("integer")
+		}
+	case "number":
+		if _, ok := val.(float64); !ok {
+			return mismatch("number")
+		}
+	case "boolean":
+		if _, ok := val.(bool); !ok {
+			return mismatch("boolean")
+		}
+	case "string":
+		str, ok := val.(string)
+		if !ok {
+			return mismatch("string")
+		}
+		if problem := validateFormat(schema.Format, str); problem != "" {
+			problems = append(problems, path+": "+problem)
+		}
+	}
+
+	return problems
+}
+
+// validateFormat checks a string against the formats used in the documentation.
+func validateFormat(format, str string) string {
+	switch format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+			return fmt.Sprintf("expected a date-time, got %q", str)
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", str); err != nil {
+			return fmt.Sprintf("expected a date, got %q", str)
+		}
+	case "uuid":
+		if !uuidRegexp.MatchString(str) {
+			return fmt.Sprintf("expected a UUID, got %q", str)
+		}
+	case "email":
+		if !strings.Contains(str, "@") {
+			return fmt.Sprintf("expected an email address, got %q", str)
+		}
+	}
+	return ""
+}
+
+func jsonType(val interface{}) string {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case float64:
+		return "number " + strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return "boolean"
+	case string:
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
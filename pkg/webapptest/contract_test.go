@@ -0,0 +1,89 @@
+package webapptest
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/internal/swagger"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+var itemSchema = swagger.Schema{
+	Type: "object",
+	Properties: map[string]*swagger.Schema{
+		"id":      {Type: "string", Format: "uuid"},
+		"name":    {Type: "string"},
+		"count":   {Type: "integer", Nullable: true},
+		"created": {Type: "string", Format: "date-time"},
+		"tags":    {Type: "array", Items: &swagger.Schema{Type: "string"}},
+	},
+	Required: []string{"id", "name"},
+}
+
+func TestValidate(t *testing.T) {
+	valid := map[string]interface{}{
+		"id":      "3fa85f64-5717-4562-b3fc-2c963f66afa6",
+		"name":    "Laptop",
+		"count":   nil,
+		"created": "2023-05-21T17:32:28.123Z",
+		"tags":    nil,
+		"extra":   true,
+	}
+	assert.Empty(t, validate(itemSchema, valid, "body"))
+	assert.Empty(t, validate(swagger.Schema{Type: "array", Items: &itemSchema}, []interface{}{valid}, "body"))
+
+	invalid := map[string]interface{}{
+		"id":      "not-a-uuid",
+		"count":   1.5,
+		"created": "yesterday",
+		"tags":    []interface{}{"a", 2.0},
+	}
+	assert.Equal(t, []string{
+		"body.name: required, but missing",
+		"body.count: expected integer, got number 1.5",
+		`body.created: expected a date-time, got "yesterday"`,
+		`body.id: expected a UUID, got "not-a-uuid"`,
+		"body.tags.1: expected string, got number 2",
+	}, validate(itemSchema, invalid, "body"))
+
+	assert.Equal(t, []string{`body: expected object, got "pong"`}, validate(itemSchema, "pong", "body"))
+	assert.Equal(t, []string{"body.name: expected string, got null"},
+		validate(itemSchema, map[string]interface{}{"id": "3fa85f64-5717-4562-b3fc-2c963f66afa6", "name": nil}, "body"))
+	assert.Empty(t, validate(swagger.Schema{}, "anything", "body"))
+}
+
+func TestExample(t *testing.T) {
+	item := example(itemSchema).(map[string]interface{})
+	assert.Empty(t, validate(itemSchema, normalise(t, item), "body"))
+	assert.Equal(t, "3fa85f64-5717-4562-b3fc-2c963f66afa6", item["id"])
+
+	documented := map[string]interface{}{"name": "John Doe"}
+	assert.Equal(t, documented, example(swagger.Schema{Type: "object", Example: documented}))
+	assert.Equal(t, 1, example(swagger.Schema{}))
+}
+
+func TestCheckServerErrors(t *testing.T) {
+	s := testServer(t)
+	s.Router.GET("/fail", func(c *gin.Context) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+	op := operation{name: "GET /fail", method: http.MethodGet, path: "/fail", docs: &swagger.Operation{
+		Responses: map[int]swagger.Response{http.StatusInternalServerError: {}},
+	}}
+
+	rec := &recorder{TB: t}
+	newContract(nil).check(Do(rec, s).GET("/fail").Send(), op)
+	if assert.Len(t, rec.errors, 1) {
+		assert.Contains(t, rec.errors[0], "GET /fail responded with a server error")
+	}
+
+	rec = &recorder{TB: t}
+	newContract([]ContractOption{AllowServerErrors()}).check(Do(rec, s).GET("/fail").Send(), op)
+	assert.Empty(t, rec.errors)
+}
+
+func normalise(t *testing.T, val interface{}) interface{} {
+	normalised, err := normaliseJSON(val)
+	assert.Nil(t, err)
+	return normalised
+}
@@ -0,0 +1,161 @@
+package webapptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/kaphos/webapp"
+	"github.com/kaphos/webapp/internal/swagger"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// maxNesting is deeper than encoding/json allows, so that nesting limits are exercised.
+const maxNesting = 10001
+
+// Fuzz fuzzes the request bodies of every documented operation that takes a JSON payload
+// (e.g. handlers created using handler.NewP), using Go's native fuzzing:
+//
+//	func FuzzPayloads(f *testing.F) {
+//		webapptest.Fuzz(f, setupServer())
+//	}
+//
+// The corpus is seeded, for each operation, with the documented example body, along with
+// variants of it that are likely to break handlers (e.g. missing or mistyped fields, huge
+// numbers, deeply nested arrays and invalid UTF-8), which also run as regular tests using
+// "go test". Run "go test -fuzz FuzzPayloads" to generate further bodies. The fuzz test fails
+// if a handler panics, responds with a 5xx status code, or responds with a status code that
+// is not documented. Options are as for Contract; SkipOperation can be used to skip
+// operations that cannot succeed in the test environment (e.g. as they need a database).
+func Fuzz(f *testing.F, s *webapp.Server, opts ...ContractOption) {
+	c := newContract(opts)
+
+	operations := make([]operation, 0)
+	for _, op := range c.operations(s) {
+		if _, ok := op.bodySchema(); ok && !c.skip[op.name] {
+			operations = append(operations, op)
+		}
+	}
+	if len(operations) == 0 {
+		f.Skip("no documented operations take a JSON payload")
+	}
+
+	for i, op := range operations {
+		schema, _ := op.bodySchema()
+		for _, seed := range seeds(schema) {
+			f.Add(uint16(i), seed)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, index uint16, body []byte) {
+		op := operations[int(index)%len(operations)]
+		resp := c.send(t, s, op, body)
+		if resp.Code() >= http.StatusInternalServerError {
+			resp.fail("%s responded with a server error", op.name)
+			return
+		}
+		checkStatus(resp, op)
+	})
+}
+
+// nested stands for a deeply nested array (see deeplyNested), spliced into bodies once they
+// are encoded, as encoding/json refuses to encode it.
+type nested struct{}
+
+const nestedMarker = `"webapptest:nested"`
+
+func (nested) MarshalJSON() ([]byte, error) { return []byte(nestedMarker), nil }
+
+func deeplyNested() []byte {
+	return []byte(strings.Repeat("[", maxNesting) + strings.Repeat("]", maxNesting))
+}
+
+// seeds returns request bodies for a payload schema: its example, and variants of it.
+func seeds(schema swagger.Schema) [][]byte {
+	bodies := [][]byte{
+		[]byte(""),
+		[]byte("null"),
+		[]byte("[]"),
+		[]byte("{"),
+		[]byte(`"string value"`),
+		[]byte("\xff\xfe"),
+		deeplyNested(),
+	}
+
+	valid := example(schema)
+	if encoded, err := json.Marshal(valid); err == nil {
+		bodies = append(bodies, encoded)
+	}
+
+	obj, ok := valid.(map[string]interface{})
+	if !ok {
+		return bodies
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property := swagger.Schema{}
+		if schema.Properties[name] != nil {
+			property = *schema.Properties[name]
+		}
+
+		for _, variant := range variants(property) {
+			mutated := make(map[string]interface{}, len(obj))
+			for key, val := range obj {
+				mutated[key] = val
+			}
+			if variant == nil {
+				delete(mutated, name)
+			} else {
+				mutated[name] = variant
+			}
+
+			if encoded, err := json.Marshal(mutated); err == nil {
+				bodies = append(bodies, bytes.Replace(encoded, []byte(nestedMarker), deeplyNested(), 1))
+			}
+		}
+	}
+
+	return bodies
+}
+
+// variants returns values likely to break handlers for a property with the given schema.
+// nil stands for the property being left out.
+func variants(schema swagger.Schema) []interface{} {
+	values := []interface{}{
+		nil,
+		json.RawMessage("null"),
+		nested{},
+	}
+
+	switch schema.Type {
+	case "integer", "number":
+		values = append(values,
+			"string value",
+			json.RawMessage("1e400"),
+			json.RawMessage("-1e400"),
+			json.RawMessage("9223372036854775808"),
+			json.RawMessage("-9223372036854775809"),
+			json.RawMessage("0.5"),
+			json.RawMessage("-1"),
+		)
+	case "string":
+		values = append(values,
+			12345,
+			"",
+			strings.Repeat("a", 1<<16),
+			json.RawMessage("\"\xff\xfe\""),
+			"\u0000",
+		)
+	default:
+		values = append(values, "string value", 12345)
+	}
+
+	return values
+}
@@ -0,0 +1,42 @@
+package webapptest
+
+import (
+	"encoding/json"
+	"github.com/kaphos/webapp/internal/swagger"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSeeds(t *testing.T) {
+	schema := swagger.Schema{
+		Type: "object",
+		Properties: map[string]*swagger.Schema{
+			"name":  {Type: "string"},
+			"count": {Type: "integer"},
+		},
+	}
+
+	bodies := make([]string, 0)
+	for _, seed := range seeds(schema) {
+		bodies = append(bodies, string(seed))
+	}
+
+	assert.Len(t, bodies, 7+1+(3+7)+(3+5))
+	assert.Contains(t, bodies, `{"count":123,"name":"string value"}`)
+	assert.Contains(t, bodies, `{"name":"string value"}`)
+	assert.Contains(t, bodies, `{"count":1e400,"name":"string value"}`)
+	assert.Contains(t, bodies, `{"count":"string value","name":"string value"}`)
+	assert.Contains(t, bodies, "{\"count\":123,\"name\":\"\xff\xfe\"}")
+	assert.Contains(t, bodies, `{"count":123,"name":null}`)
+	assert.Contains(t, bodies, `{"count":`+string(deeplyNested())+`,"name":"string value"}`)
+
+	valid := 0
+	for _, body := range bodies {
+		if json.Valid([]byte(body)) {
+			valid++
+		}
+	}
+	assert.Less(t, valid, len(bodies)) // some seeds are deliberately invalid JSON
+
+	assert.Len(t, seeds(swagger.Schema{}), 8) // no per-property variants for non-objects
+}
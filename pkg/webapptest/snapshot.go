@@ -0,0 +1,113 @@
+package webapptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// masked replaces volatile values in snapshots.
+const masked = "<masked>"
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ExpectSnapshot compares the response (its status code and body) against the golden file
+// testdata/snapshots/<name>.golden, relative to the test's package. In JSON bodies, the
+// values of the given keys (at any depth, e.g. "id" or "created"), and any strings that are
+// UUIDs or RFC 3339 timestamps, are masked, so that snapshots are stable across runs.
+//
+// Golden files are only written (instead of compared against) if UPDATE_SNAPSHOTS is set to
+// "true"; review the changes before committing them. The test fails if one does not exist
+// otherwise, so that a missing golden file is not silently recreated (e.g. in CI).
+func (r *Response) ExpectSnapshot(name string, maskKeys ...string) *Response {
+	r.t.Helper()
+
+	actual := r.snapshot(maskKeys)
+	file := filepath.Join("testdata", "snapshots", name+".golden")
+
+	if os.Getenv("UPDATE_SNAPSHOTS") == "true" {
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			r.t.Fatalf("webapptest: unable to create snapshot directory: %s", err)
+		}
+		if err := os.WriteFile(file, actual, 0o644); err != nil {
+			r.t.Fatalf("webapptest: unable to write snapshot: %s", err)
+		}
+		r.t.Logf("webapptest: wrote snapshot %s", file)
+		return r
+	}
+
+	expected, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		r.fail("snapshot %s does not exist (set UPDATE_SNAPSHOTS=true to create it)", file)
+		return r
+	} else if err != nil {
+		r.t.Fatalf("webapptest: unable to read snapshot: %s", err)
+	}
+
+	if !bytes.Equal(expected, actual) {
+		r.fail("response does not match snapshot %s (set UPDATE_SNAPSHOTS=true to update it)\n\n--- Expected ---\n%s\n--- Actual ---\n%s", file, expected, actual)
+	}
+	return r
+}
+
+// snapshot returns the contents of the response's golden file: its status code, followed by
+// its body, indented if JSON, with volatile values masked.
+func (r *Response) snapshot(maskKeys []string) []byte {
+	body := r.body
+
+	var doc interface{}
+	if json.Unmarshal(body, &doc) == nil {
+		keys := make(map[string]bool, len(maskKeys))
+		for _, key := range maskKeys {
+			keys[key] = true
+		}
+		var indented bytes.Buffer
+		encoder := json.NewEncoder(&indented)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(mask(doc, keys)); err == nil {
+			body = bytes.TrimSuffix(indented.Bytes(), []byte("\n"))
+		}
+	}
+
+	var out bytes.Buffer
+	out.WriteString(strconv.Itoa(r.Recorder.Code) + " " + http.StatusText(r.Recorder.Code) + "\n\n")
+	out.Write(body)
+	out.WriteString("\n")
+	return out.Bytes()
+}
+
+// mask replaces the volatile values in a decoded JSON document.
+func mask(val interface{}, keys map[string]bool) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if keys[key] && child != nil {
+				v[key] = masked
+			} else {
+				v[key] = mask(child, keys)
+			}
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = mask(child, keys)
+		}
+		return v
+	case string:
+		if uuidRegexp.MatchString(v) {
+			return masked
+		}
+		if _, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return masked
+		}
+		return v
+	default:
+		return v
+	}
+}
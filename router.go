@@ -2,7 +2,10 @@ package webapp
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/kaphos/webapp/internal/httpbase"
+	"github.com/kaphos/webapp/internal/swagger"
 	"github.com/kaphos/webapp/internal/telemetry"
+	"github.com/kaphos/webapp/pkg/db"
 	"github.com/kaphos/webapp/pkg/errchk"
 	"github.com/kaphos/webapp/pkg/utils"
 	"net/http"
@@ -32,11 +35,35 @@ func (s *Server) loggerMiddleware(c *gin.Context) {
 	sb.WriteString(status)
 	sb.WriteString(" ")
 	sb.WriteString(c.Request.URL.Path)
+	if queries, dbTime := db.QueryStats(c.Request.Context()); queries > 0 {
+		sb.WriteString(" [db: ")
+		sb.WriteString(strconv.FormatInt(queries, 10))
+		sb.WriteString(" queries, ")
+		sb.WriteString(dbTime.String())
+		sb.WriteString("]")
+	}
 	routerLogger.Info(sb.String())
 
+	if streamType, ok := httpbase.LongLived(c); ok {
+		// Kept separate, as long-lived connections would skew the request latencies
+		telemetry.PromLogStream(streamType, status, latency.Seconds())
+		return
+	}
+
 	telemetry.PromLogRequest(method, status, latency.Seconds())
 }
 
+// lifecycleMiddleware makes the server's shutdown signal available to handlers,
+// so that long-lived connections can be closed when the server shuts down,
+// along with the database passed to repos, for use by middleware. Also tracks database writes made
+// by the request, so that its later reads are not sent to a replica.
+func (s *Server) lifecycleMiddleware(c *gin.Context) {
+	httpbase.SetShutdownContext(c, s.shutdown)
+	httpbase.SetDatabase(c, s.repoDB)
+	c.Request = c.Request.WithContext(db.WithRequestScope(c.Request.Context()))
+	c.Next()
+}
+
 func (s *Server) buildRouter() {
 	if os.Getenv("DEBUG") != "true" {
 		// Hide debug messages, unless DEBUG flag is set
@@ -55,12 +82,14 @@ func (s *Server) buildRouter() {
 	})
 
 	apiGroup := router.Group("/api")
-	apiGroup.Use(s.loggerMiddleware)
+	apiGroup.Use(s.loggerMiddleware, s.lifecycleMiddleware)
 
 	apiGroup.GET("/version", func(c *gin.Context) {
 		c.String(200, utils.GetEnv("VERSION", "v0.0.0"))
 	})
 
+	apiDocs := swagger.Generate(s.appName, s.version)
+
 	s.Router = router
-	s.apiRouter = apiGroup
+	s.api = newGroup(s.logger, s.repoDB, apiGroup, &apiDocs)
 }
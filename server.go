@@ -1,114 +1,185 @@
 package webapp
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/kaphos/webapp/internal/httpbase"
 	"github.com/kaphos/webapp/internal/log"
-	"github.com/kaphos/webapp/internal/swagger"
 	"github.com/kaphos/webapp/internal/telemetry"
 	"github.com/kaphos/webapp/pkg/db"
+	"github.com/kaphos/webapp/pkg/db/fixtures"
+	"github.com/kaphos/webapp/pkg/db/migrate"
 	"github.com/kaphos/webapp/pkg/errchk"
 	"github.com/kaphos/webapp/pkg/repo"
+	"github.com/kaphos/webapp/pkg/utils"
 	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"syscall"
+	"time"
 )
 
 var routerLogger = log.Get("ROUTE")
 
 type Server struct {
-	logger    *zap.Logger
-	tracer    trace.Tracer
-	DB        *db.Database
-	Router    *gin.Engine
-	apiRouter *gin.RouterGroup
-	apiDocs   *swagger.OpenAPI
+	appName string
+	version string
+	logger  *zap.Logger
+	tracer  trace.Tracer
+	DB      *db.Database
+	repoDB  db.DB // passed to repos; DB, unless WithDatabase is used
+	Router  *gin.Engine
+	api     *Group            // default group, mounted under "/api"
+	groups  map[string]*Group // further groups (e.g. versions), keyed by base path
+
+	migrator *migrate.Migrator  // nil unless WithMigrations is used
+	queries  *db.Queries        // nil unless WithQueries is used
+	fixtures *fixtures.Fixtures // nil unless WithFixtures is used
+
+	httpServer *http.Server
+	shutdown   context.Context // cancelled once Shutdown is called
+	stop       context.CancelFunc
 }
 
 // NewServer returns a new Server object, while performing
 // all initialisation as required (Sentry, tracing, database).
-func NewServer(appName, version, dbUser, dbPass string, dbConns int32) (Server, error) {
+// Further behaviour can be configured by passing in ServerOptions.
+func NewServer(appName, version, dbUser, dbPass string, dbConns int32, opts ...ServerOption) (Server, error) {
 	// Initialise Sentry first, so that any errors that come up can be flagged
 	errchk.InitSentry()
 
-	apiDocs := swagger.Generate(appName, version)
-
 	server := Server{
+		appName: appName,
+		version: version,
 		logger:  log.Get("MAIN"),
 		tracer:  telemetry.NewTracer(appName, "server"),
-		apiDocs: &apiDocs,
+		groups:  make(map[string]*Group),
+	}
+	server.shutdown, server.stop = context.WithCancel(context.Background())
+
+	for _, opt := range opts {
+		if err := opt(&server); errchk.HaveError(err, "initOption") {
+			return Server{}, err
+		}
 	}
 
+	// If repos are given another database (see WithDatabase), there may be no Postgres to wait for
+	connect := db.NewDB
+	if server.repoDB != nil {
+		connect = db.NewLazyDB
+	}
 	var err error
-	server.DB, err = db.NewDB(appName, dbUser, dbPass, dbConns)
+	server.DB, err = connect(appName, dbUser, dbPass, dbConns)
 	if errchk.HaveError(err, "initDB") {
 		return Server{}, err
 	}
+	if server.repoDB == nil {
+		server.repoDB = server.DB
+	}
+
+	if server.migrator != nil && utils.GetEnv("MIGRATE_ON_START", "false") == "true" {
+		_, err = server.migrator.Migrate(context.Background(), server.DB, migrate.Latest, false)
+		if errchk.HaveError(err, "initMigrate") {
+			return Server{}, err
+		}
+	}
 
 	server.buildRouter()
+	// Created here rather than in Start, so that Shutdown (typically called from another
+	// goroutine) does not race with it
+	server.httpServer = &http.Server{Handler: server.Router}
 
 	return server, nil
 }
 
 var pathRegexp = regexp.MustCompile("//+")
 
-func buildPath(r httpbase.I, h httpbase.I) string {
-	path := "/" + r.RelativePath() + "/" + h.RelativePath() + "/"
+func buildPath(prefix string, h httpbase.I) string {
+	path := "/" + prefix + "/" + h.RelativePath() + "/"
 	path = pathRegexp.ReplaceAllString(path, "/")
 	return path
 }
 
-func (s *Server) addAPIPath(r repo.RepoI, h httpbase.HandlerBaseI, path, summary, description string,
-	params map[string]swagger.SimpleParam) {
-	// Build the list of potential responses by both the repo and handlers.
-	responses := make(map[int]swagger.Response)
+// Attach a Repo to the server, under the default "/api" group. Initialises the repository by
+// passing in the database connection and a tracer object, and adds each of the repository's
+// handlers to the server's Gin engine. Use Version or Group to attach repos elsewhere.
+func (s *Server) Attach(r repo.RepoI) {
+	s.api.Attach(r)
+}
 
-	for code, resp := range r.Responses() {
-		responses[code] = resp
+// Start the Gin engine/router. If migrations were registered (see WithMigrations), refuses to
+// start if the database is not compatible with them, and likewise if any named queries (see
+// WithQueries) fail to prepare, or cannot be scanned into their targets. Blocks until the
+// server is shut down, either by calling Shutdown, or by receiving SIGINT/SIGTERM (in which
+// case long-lived connections are given up to SHUTDOWN_TIMEOUT, default 10s, to drain).
+func (s *Server) Start() error {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "5000"
 	}
 
-	for code, resp := range h.Responses() { // Handler after repo, to overwrite anything that may have been declared
-		responses[code] = resp
+	if s.migrator != nil {
+		if err := s.migrator.Verify(context.Background(), s.DB); errchk.HaveError(err, "startMigrate") {
+			return err
+		}
 	}
 
-	authGroups := append(make([]string, 0), r.AuthGroups()...)
-	authGroups = append(authGroups, h.AuthGroups()...)
+	if s.queries != nil {
+		if err := s.queries.Prepare(context.Background(), s.DB); errchk.HaveError(err, "startQueries") {
+			return err
+		}
+	}
 
-	s.apiDocs.AddPath(h.Type(), r.RelativePath(), h.Method(), path, summary, description, params, authGroups, responses)
-}
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
 
-// Attach a Repo to the server. Initialises the repository by passing in the database connection
-// and a tracer object, and adds each of the repository's handlers to the server's Gin engine.
-func (s *Server) Attach(r repo.RepoI) {
-	s.logger.Debug(fmt.Sprintf("Attaching repo \"%s\"", r.RelativePath()))
-	r.Init(s.DB)
+	go s.shutdownOnSignal()
+
+	s.logger.Info("Listening on port " + port)
 
-	group := s.apiRouter.Group(r.RelativePath(), *r.Middleware()...)
+	if err := s.httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 
-	for _, h := range *r.GetHandlers() {
-		path := buildPath(r, h)
-		s.logger.Debug(fmt.Sprintf(" - Attaching handler at \"%s\" (%s)", path, h.Method()))
+	return nil
+}
 
-		handlers := make([]gin.HandlerFunc, 0)
-		handlers = append(handlers, *h.Middleware()...)
-		handlers = append(handlers, h.Handle)
-		group.Handle(h.Method(), h.RelativePath(), handlers...)
+func (s *Server) shutdownOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
-		// Build Swagger API
-		s.addAPIPath(r, h, path, h.Summary(), h.Description(), h.Params())
+	select {
+	case <-signals:
+	case <-s.shutdown.Done():
+		return
 	}
-}
 
-// Start the Gin engine/router.
-func (s *Server) Start() error {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "5000"
+	timeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil {
+		timeout = 10 * time.Second
 	}
 
-	s.logger.Info("Listening on port " + port)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errchk.Check(s.Shutdown(ctx), "shutdown")
+}
+
+// Shutdown gracefully stops the server. Long-lived connections (e.g. Server-Sent Events)
+// are signalled to close, database subscriptions (see db.Database.Listen) are ended, and
+// in-flight requests are given until ctx is done to complete.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Info("Shutting down")
+	s.stop()
+	s.DB.StopListening()
 
-	return s.Router.Run(":" + port)
+	return s.httpServer.Shutdown(ctx)
 }
@@ -1,18 +1,35 @@
 package webapp
 
+import (
+	"github.com/kaphos/webapp/internal/apidocs"
+	"github.com/kaphos/webapp/internal/swagger"
+)
+
 // APIServer contains the data of an OpenAPI-spec server.
 type APIServer struct {
 	URL         string
 	Description string
 }
 
-// GenDocs writes an OpenAPI documentation in JSON at the provided filename.
-// "servers" is used just to decorate the file (as part of the OpenAPI spec,
-// rather than being functional).
+// GenDocs writes an OpenAPI documentation in JSON at the provided filename, for
+// repos attached to the default "/api" group. "servers" is used just to decorate
+// the file (as part of the OpenAPI spec, rather than being functional).
 func (s *Server) GenDocs(servers []APIServer, filename string) error {
-	for _, server := range servers {
-		s.apiDocs.AddServer(server.URL, server.Description)
+	return s.api.GenDocs(servers, filename)
+}
+
+func init() {
+	apidocs.Of = func(server interface{}) map[string]*swagger.OpenAPI {
+		return server.(*Server).docs()
 	}
+}
 
-	return s.apiDocs.Write(filename)
+// docs returns the OpenAPI documentation of every group (including the default "/api"
+// group), as built from the repos attached so far, keyed by base path.
+func (s *Server) docs() map[string]*swagger.OpenAPI {
+	docs := map[string]*swagger.OpenAPI{s.api.BasePath(): s.api.apiDocs}
+	for path, g := range s.groups {
+		docs[path] = g.apiDocs
+	}
+	return docs
 }